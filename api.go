@@ -1,6 +1,7 @@
 package telehash
 
 import (
+	"context"
 	"encoding/json"
 	"net"
 	"time"
@@ -66,7 +67,11 @@ func (e *Endpoint) LocalIdentity() (*Identity, error) {
 }
 
 func (e *Endpoint) Dial(identifier Identifier) (*Exchange, error) {
-	inner, err := e.inner.Dial(e3x.Identifier(identifier))
+	return e.DialContext(context.Background(), identifier)
+}
+
+func (e *Endpoint) DialContext(ctx context.Context, identifier Identifier) (*Exchange, error) {
+	inner, err := e.inner.DialContext(ctx, e3x.Identifier(identifier))
 	if err != nil {
 		return nil, err
 	}
@@ -75,7 +80,11 @@ func (e *Endpoint) Dial(identifier Identifier) (*Exchange, error) {
 }
 
 func (e *Endpoint) Open(identifier Identifier, typ string, reliable bool) (*Channel, error) {
-	inner, err := e.inner.Open(identifier, typ, reliable)
+	return e.OpenContext(context.Background(), identifier, typ, reliable)
+}
+
+func (e *Endpoint) OpenContext(ctx context.Context, identifier Identifier, typ string, reliable bool) (*Channel, error) {
+	inner, err := e.inner.OpenContext(ctx, identifier, typ, reliable)
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +97,11 @@ func (x *Exchange) RemoteIdentity() *Identity {
 }
 
 func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
-	inner, err := x.inner.Open(typ, reliable)
+	return x.OpenContext(context.Background(), typ, reliable)
+}
+
+func (x *Exchange) OpenContext(ctx context.Context, typ string, reliable bool) (*Channel, error) {
+	inner, err := x.inner.OpenContext(ctx, typ, reliable)
 	if err != nil {
 		return nil, err
 	}
@@ -105,7 +118,11 @@ func (l *Listener) Accept() (net.Conn, error) {
 }
 
 func (l *Listener) AcceptChannel() (*Channel, error) {
-	inner, err := l.inner.AcceptChannel()
+	return l.AcceptChannelContext(context.Background())
+}
+
+func (l *Listener) AcceptChannelContext(ctx context.Context) (*Channel, error) {
+	inner, err := l.inner.AcceptChannelContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -129,12 +146,20 @@ func (c *Channel) WritePacket(pkt *Packet) error {
 	return c.inner.WritePacket((*lob.Packet)(pkt))
 }
 
+func (c *Channel) WritePacketContext(ctx context.Context, pkt *Packet) error {
+	return c.inner.WritePacketContext(ctx, (*lob.Packet)(pkt))
+}
+
 func (c *Channel) Write(b []byte) (int, error) {
 	return c.inner.Write(b)
 }
 
 func (c *Channel) ReadPacket() (*Packet, error) {
-	inner, err := c.inner.ReadPacket()
+	return c.ReadPacketContext(context.Background())
+}
+
+func (c *Channel) ReadPacketContext(ctx context.Context) (*Packet, error) {
+	inner, err := c.inner.ReadPacketContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -169,6 +194,18 @@ func (c *Channel) Close() error {
 	return c.inner.Close()
 }
 
+func (c *Channel) Reject(code e3x.RejectCode, reason string) error {
+	return c.inner.Reject(code, reason)
+}
+
+func (c *Channel) CloseWrite() error {
+	return c.inner.CloseWrite()
+}
+
+func (c *Channel) CloseRead() error {
+	return c.inner.CloseRead()
+}
+
 func (i *Identity) Hashname() Hashname {
 	return Hashname(i.inner.Hashname())
 }
@@ -1,8 +1,8 @@
 package e3x
 
 import (
+	"context"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -26,7 +26,14 @@ func (err UnreachableEndpointError) Error() string {
 	return "e3x: unreachable endpoint " + string(err)
 }
 
-var ErrTimeout = errors.New("e3x: deadline reached")
+// ErrTimeout is returned when a deadline is reached. It implements net.Error.
+var ErrTimeout error = &timeoutError{}
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "e3x: deadline reached" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
 
 type BrokenChannelError struct {
 	hn  hashname.H
@@ -42,6 +49,7 @@ const (
 	cReadBufferSize  = 100
 	cWriteBufferSize = 100
 	earlyAdHocAck    = 50
+	cAckDelay        = 20 * time.Millisecond
 	cBlankSeq        = uint32(0)
 	cInitialSeq      = uint32(1)
 )
@@ -54,6 +62,12 @@ type Channel struct {
 	cndWrite *sync.Cond
 	cndClose *sync.Cond
 
+	wmtx       sync.Mutex // serializes WriteMessage
+	rmtx       sync.Mutex // serializes ReadMessage
+	oMessageID uint32
+	iMessageID uint32
+	iMessages  map[uint32]*pendingMessage
+
 	x            exchangeI
 	channelHooks ChannelHooks
 	serverside   bool
@@ -62,6 +76,8 @@ type Channel struct {
 	hashname     hashname.H
 	reliable     bool
 	broken       bool
+	remoteErr    error // set when the peer sent an "err" header
+	openHeaders  map[string]interface{}
 
 	oSeq         uint32 // highest seq in write stream
 	iBufferedSeq uint32 // highest buffered seq in read stream
@@ -69,11 +85,27 @@ type Channel struct {
 	iSeq         uint32 // highest seq in read stream
 	oAckedSeq    uint32 // highest acked seq in write stream
 	iAckedSeq    uint32 // highest acked seq in read stream
+	iWindow      uint32 // number of unread packets we are willing to buffer
+	oWindow      uint32 // number of unread packets the peer is willing to buffer
+	oSkipSeq     uint32 // highest abandoned seq in write stream
+	iSkipSeq     uint32 // highest seq the peer abandoned in read stream
+
+	prLifetime       time.Duration // partial reliability: max age of unacked packets
+	prMaxRetransmits int           // partial reliability: max retransmits per packet
 
 	deliveredEnd bool
 	receivedEnd  bool
 	readEnd      bool
+	readClosed   bool
 	needsResend  bool
+	ackScheduled bool
+
+	cc         CongestionController
+	recoverSeq uint32 // highest seq in write stream when the last loss was detected
+	rtt        rttEstimator
+	stats      counters
+	priority   Priority
+	weight     int
 
 	openDeadlineReached  bool
 	writeDeadlineReached bool
@@ -108,7 +140,9 @@ type readBufferEntry struct {
 type writeBufferEntry struct {
 	pkt        *lob.Packet
 	end        bool
+	sentAt     time.Time
 	lastResend time.Time
+	resends    int
 	dst        *Pipe
 }
 
@@ -133,6 +167,10 @@ func newChannel(
 		iSeq:         cBlankSeq,
 		oAckedSeq:    cBlankSeq,
 		iAckedSeq:    cBlankSeq,
+		iWindow:      cReadBufferSize,
+		oWindow:      cReadBufferSize,
+		weight:       1,
+		rtt:          newRTTEstimator(),
 	}
 
 	c.cndRead = sync.NewCond(&c.mtx)
@@ -147,7 +185,8 @@ func newChannel(
 	c.tWriteDeadline.Stop()
 
 	if reliable {
-		c.tResend = time.AfterFunc(1*time.Second, c.resendLastPacket)
+		c.cc = NewRenoController()
+		c.tResend = time.AfterFunc(cInitialRTO, c.resendOldestPacket)
 		c.tAcker = time.AfterFunc(10*time.Second, c.autoDeliverAck)
 	}
 
@@ -234,6 +273,13 @@ func (c *Channel) traceWrite(pkt *lob.Packet, p *Pipe) {
 	}
 }
 
+// dropPacket records that pkt was dropped because of reason.
+func (c *Channel) dropPacket(pkt *lob.Packet, reason *DropError) {
+	c.traceDroppedPacket(pkt, reason.Reason)
+	c.stats.drop(reason.Reason)
+	c.channelHooks.DropPacket(pkt, reason)
+}
+
 func (c *Channel) traceDroppedPacket(pkt *lob.Packet, reason string) {
 	if tracer.Enabled {
 		info := tracer.Info{
@@ -283,27 +329,43 @@ func (c *Channel) Exchange() *Exchange {
 	return nil
 }
 
-func (e *Endpoint) Open(i Identifier, typ string, reliable bool) (*Channel, error) {
-	x, err := e.Dial(i)
+func (e *Endpoint) Open(i Identifier, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	return e.OpenContext(context.Background(), i, typ, reliable, options...)
+}
+
+// OpenContext is like Open but gives up dialing and opening when ctx is done.
+func (e *Endpoint) OpenContext(ctx context.Context, i Identifier, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	x, err := e.DialContext(ctx, i)
 	if err != nil {
 		return nil, err
 	}
 
-	return x.Open(typ, reliable)
+	return x.OpenContext(ctx, typ, reliable, options...)
 }
 
 func (c *Channel) WritePacket(pkt *lob.Packet) error {
-	return c.WritePacketTo(pkt, nil)
+	return c.writePacketTo(context.Background(), pkt, nil)
 }
 
 func (c *Channel) WritePacketTo(pkt *lob.Packet, p *Pipe) error {
+	return c.writePacketTo(context.Background(), pkt, p)
+}
+
+// WritePacketContext is like WritePacket but returns ctx.Err() when ctx is
+// done before the packet could be written.
+func (c *Channel) WritePacketContext(ctx context.Context, pkt *lob.Packet) error {
+	return c.writePacketTo(ctx, pkt, nil)
+}
+
+func (c *Channel) writePacketTo(ctx context.Context, pkt *lob.Packet, p *Pipe) error {
 	if c == nil {
 		return os.ErrInvalid
 	}
 
 	c.mtx.Lock()
-	for c.blockWrite() {
-		c.cndWrite.Wait()
+	if err := waitContext(ctx, c.cndWrite, c.blockWrite); err != nil {
+		c.mtx.Unlock()
+		return err
 	}
 
 	err := c.write(pkt, p)
@@ -320,6 +382,12 @@ func (c *Channel) WritePacketTo(pkt *lob.Packet, p *Pipe) error {
 }
 
 func (c *Channel) blockWrite() bool {
+	if c.broken {
+		// When a channel is marked as broken the all writes
+		// must return a BrokenChannelError.
+		return false
+	}
+
 	if c.writeDeadlineReached {
 		// Never block when the write deadline is reached
 		return false
@@ -331,8 +399,8 @@ func (c *Channel) blockWrite() bool {
 		return true
 	}
 
-	if !c.serverside && (c.iSeq == cBlankSeq && c.oAckedSeq == cBlankSeq) && c.oSeq >= cInitialSeq {
-		// When a client channel sent a packet but did not yet read a response
+	if !c.serverside && (c.iBufferedSeq == cBlankSeq && c.oAckedSeq == cBlankSeq) && c.oSeq >= cInitialSeq {
+		// When a client channel sent a packet but did not yet receive a response
 		// to the initial packet then subsequent writes must be deferred.
 		return true
 	}
@@ -343,6 +411,24 @@ func (c *Channel) blockWrite() bool {
 		return true
 	}
 
+	if c.cc != nil && len(c.writeBuffer) >= c.cc.Window() {
+		// When a channel filled its congestion window then
+		// all writes must be deferred.
+		return true
+	}
+
+	if x := c.Exchange(); c.reliable && x != nil && !x.sched.hasRoom() {
+		// When a reliable channel finds the send queue of the exchange full
+		// then all writes must be deferred until the queue drained.
+		return true
+	}
+
+	if c.reliable && c.oSeq-c.oAckedSeq >= c.oWindow {
+		// When a channel filled the receive window of the peer then
+		// all writes must be deferred until the peer read some packets.
+		return true
+	}
+
 	return false
 }
 
@@ -355,7 +441,7 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 		// When a channel is marked as broken the all writes
 		// must return a BrokenChannelError.
 		return c.traceWriteError(pkt, p,
-			&BrokenChannelError{c.hashname, c.typ, c.id})
+			c.brokenError())
 	}
 
 	if c.writeDeadlineReached {
@@ -380,6 +466,7 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 	}
 	if !c.serverside && c.oSeq == cInitialSeq {
 		hdr.Type, hdr.HasType = c.typ, true
+		c.applyOpenHeaders(pkt)
 	}
 
 	end := hdr.HasEnd && hdr.End
@@ -389,18 +476,30 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 	}
 
 	if c.reliable {
-		if c.oSeq%30 == 0 || hdr.End {
+		if c.oSeq == cInitialSeq {
+			c.applyWindowHeader(pkt)
+		}
+		if c.oSeq%30 == 0 || hdr.End || c.iSeq > c.iAckedSeq || c.oSkipSeq > c.oAckedSeq {
 			c.applyAckHeaders(pkt)
 		}
-		c.writeBuffer[c.oSeq] = &writeBufferEntry{pkt, end, time.Time{}, p}
+		c.writeBuffer[c.oSeq] = &writeBufferEntry{pkt: pkt, end: end, sentAt: time.Now(), dst: p}
 		c.needsResend = false
 	}
 
 	err := c.x.deliverPacket(pkt, p)
+	if err == ErrSendQueueFull {
+		// The (unreliable) packet was not sent; reliable packets are never
+		// dropped by the scheduler (see blockWrite).
+		c.oSeq--
+		if end {
+			c.deliveredEnd = false
+		}
+	}
 	if err != nil {
 		return c.traceWriteError(pkt, p, err)
 	}
 	statChannelSndPkt.Add(1)
+	c.stats.sent(pkt.BodyLen())
 	if pkt.Header().HasAck {
 		statChannelSndAckInline.Add(1)
 	}
@@ -419,13 +518,20 @@ func (c *Channel) write(pkt *lob.Packet, p *Pipe) error {
 }
 
 func (c *Channel) ReadPacket() (*lob.Packet, error) {
+	return c.ReadPacketContext(context.Background())
+}
+
+// ReadPacketContext is like ReadPacket but returns ctx.Err() when ctx is
+// done before a packet could be read.
+func (c *Channel) ReadPacketContext(ctx context.Context) (*lob.Packet, error) {
 	if c == nil {
 		return nil, os.ErrInvalid
 	}
 
 	c.mtx.Lock()
-	for c.blockRead() {
-		c.cndRead.Wait()
+	if err := waitContext(ctx, c.cndRead, c.blockRead); err != nil {
+		c.mtx.Unlock()
+		return nil, err
 	}
 
 	pkt, err := c.peekPacket()
@@ -450,9 +556,10 @@ func (c *Channel) blockRead() bool {
 		return false
 	}
 
-	if c.readEnd {
+	if c.readEnd || c.readClosed {
 		// When a channel read a packet with the "end" header set
-		// then all subsequent reads must return io.EOF
+		// (or the read side was closed) then all subsequent reads
+		// must return io.EOF
 		return false
 	}
 
@@ -482,7 +589,7 @@ func (c *Channel) peekPacket() (*lob.Packet, error) {
 	if c.broken {
 		// When a channel is marked as broken the all reads
 		// must return a BrokenChannelError.
-		return nil, &BrokenChannelError{c.hashname, c.typ, c.id}
+		return nil, c.brokenError()
 	}
 
 	if c.readDeadlineReached {
@@ -491,9 +598,10 @@ func (c *Channel) peekPacket() (*lob.Packet, error) {
 		return nil, ErrTimeout
 	}
 
-	if c.readEnd {
+	if c.readEnd || c.readClosed {
 		// When a channel read a packet with the "end" header set
-		// then all subsequent reads must return io.EOF
+		// (or the read side was closed) then all subsequent reads
+		// must return io.EOF
 		return nil, io.EOF
 	}
 
@@ -527,6 +635,7 @@ func (c *Channel) readPacket() {
 	// remove entry
 	copy(c.readBuffer, c.readBuffer[1:])
 	c.readBuffer = c.readBuffer[:len(c.readBuffer)-1]
+	c.skipAbandonedPackets()
 
 	if e.end {
 		c.deliverAck()
@@ -538,6 +647,7 @@ func (c *Channel) readPacket() {
 	}
 
 	c.maybeDeliverAdHocAck()
+	c.maybeScheduleAck(false)
 
 	if c.deliveredEnd && !c.blockClose() {
 		c.cndClose.Signal()
@@ -551,22 +661,23 @@ func (c *Channel) readPacket() {
 }
 
 func (c *Channel) receivedPacket(pkt *lob.Packet) {
-	const (
-		errBrokenChannel   = "broken channel"
-		errMissingSeq      = "missing seq"
-		errDuplicatePacket = "duplicate packet"
-		errFullBuffer      = "full buffer"
-	)
+	c.stats.received(pkt.BodyLen())
 
 	c.mtx.Lock()
 
 	if c.broken {
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errBrokenChannel)
+		c.dropPacket(pkt, ErrBrokenChannel)
 		statChannelRcvPktDrop.Add(1)
 		return
 	}
 
+	if reason, ok := pkt.Header().GetString("err"); ok {
+		// the peer terminated the channel
+		c.receivedError(pkt, reason)
+		return
+	}
+
 	var (
 		hdr           = pkt.Header()
 		seq, hasSeq   = hdr.Seq, hdr.HasSeq
@@ -581,6 +692,9 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 		hasSeq = true
 
 	} else {
+		c.processWindowHeader(pkt)
+		c.processSkipHeader(pkt)
+
 		// determine what to drop from the write buffer
 		if hasAck {
 			if hasSeq {
@@ -591,22 +705,48 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 
 			var (
 				oldAck  = c.oAckedSeq
+				acked   int
 				changed bool
 			)
 
 			if c.oAckedSeq < ack {
 				c.oAckedSeq = ack
 				changed = true
+
+				// Karn's algorithm: only sample when none of the acked packets
+				// were resent (the ack may have been held back by a resent packet).
+				sample := c.writeBuffer[ack] != nil
+				for i := oldAck + 1; i <= ack && sample; i++ {
+					if e := c.writeBuffer[i]; e != nil && !e.lastResend.IsZero() {
+						sample = false
+					}
+				}
+				if sample {
+					c.rtt.Sample(time.Since(c.writeBuffer[ack].sentAt))
+				}
+
+				// progress was made; restart the retransmission timer.
+				c.needsResend = false
 			}
 
 			for i := oldAck + 1; i <= ack; i++ {
 				if e := c.writeBuffer[i]; e != nil {
 					e.pkt.Free()
+					acked++
 				}
 				delete(c.writeBuffer, i)
 				changed = true
 			}
 
+			if changed && len(c.writeBuffer) > 0 {
+				// the timer may still be armed with a backed-off timeout.
+				c.tResend.Reset(c.resendDelay())
+			}
+
+			if c.cc != nil {
+				c.cc.OnAck(acked)
+			}
+
 			if len(c.writeBuffer) == 0 {
 				c.needsResend = false
 			}
@@ -614,7 +754,7 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 			if changed {
 				c.cndWrite.Signal()
 				if c.deliveredEnd || c.receivedEnd {
-					c.cndClose.Signal()
+					c.cndClose.Broadcast()
 				}
 			}
 
@@ -627,9 +767,12 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 	if !hasSeq {
 		// drop: is not a valid packet
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errMissingSeq)
 
-		if !hasAck {
+		if hasAck {
+			// an ack without payload
+			c.traceDroppedPacket(pkt, ErrMissingSeq.Reason)
+		} else {
+			c.dropPacket(pkt, ErrMissingSeq)
 			statChannelRcvPktDrop.Add(1)
 		}
 
@@ -643,24 +786,28 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 
 	if seq <= c.iSeq {
 		// drop: the reader already read a packet with this seq
+		// the peer probably missed our ack.
+		c.maybeScheduleAck(true)
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errDuplicatePacket)
+		c.dropPacket(pkt, ErrDuplicatePacket)
 		statChannelRcvPktDrop.Add(1)
 		return
 	}
 
-	if len(c.readBuffer) >= cReadBufferSize {
+	if len(c.readBuffer) >= int(c.iWindow) || (c.reliable && seq > c.iSeq+c.iWindow) {
 		// drop: the read buffer is full
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errFullBuffer)
+		c.dropPacket(pkt, ErrBufferFull)
 		statChannelRcvPktDrop.Add(1)
 		return
 	}
 
 	if c.readBuffer.IndexOf(seq) >= 0 {
 		// drop: a packet with this seq is already buffered
+		// the peer probably missed our ack.
+		c.maybeScheduleAck(true)
 		c.mtx.Unlock()
-		c.traceDroppedPacket(pkt, errDuplicatePacket)
+		c.dropPacket(pkt, ErrDuplicatePacket)
 		statChannelRcvPktDrop.Add(1)
 		return
 	}
@@ -676,7 +823,20 @@ func (c *Channel) receivedPacket(pkt *lob.Packet) {
 	c.readBuffer = append(c.readBuffer, &readBufferEntry{pkt, seq, end})
 	sort.Sort(c.readBuffer)
 
+	if c.readBuffer[0].seq != c.iSeq+1 {
+		// there is a gap in the read stream;
+		// report the missing packets to the peer.
+		c.maybeScheduleAck(true)
+	}
+
+	if c.readClosed {
+		c.discardReadBuffer()
+	}
+
 	c.cndRead.Signal()
+	if !c.blockWrite() {
+		c.cndWrite.Signal()
+	}
 	c.mtx.Unlock()
 
 	c.traceReceivedPacket(pkt)
@@ -698,7 +858,7 @@ func (c *Channel) Error(err error) error {
 		// When a channel is marked as broken the all closes
 		// must return a BrokenChannelError.
 		c.mtx.Unlock()
-		return &BrokenChannelError{c.hashname, c.typ, c.id}
+		return c.brokenError()
 	}
 
 	for c.blockWrite() {
@@ -739,7 +899,7 @@ func (c *Channel) Close() error {
 		// When a channel is marked as broken the all closes
 		// must return a BrokenChannelError.
 		c.mtx.Unlock()
-		return &BrokenChannelError{c.hashname, c.typ, c.id}
+		return c.brokenError()
 	}
 
 	c.setCloseDeadline()
@@ -785,7 +945,7 @@ func (c *Channel) Close() error {
 		// When a channel is marked as broken the all closes
 		// must return a BrokenChannelError.
 		c.mtx.Unlock()
-		return &BrokenChannelError{c.hashname, c.typ, c.id}
+		return c.brokenError()
 	}
 
 	c.unsetTimers()
@@ -800,6 +960,81 @@ func (c *Channel) Close() error {
 	return nil
 }
 
+// CloseWrite shuts down the writing side of the channel by sending an "end"
+// packet. The channel can still be read until the peer closes its side.
+// Subsequent writes return io.EOF. Most callers should call Close afterwards.
+func (c *Channel) CloseWrite() error {
+	if c == nil {
+		return os.ErrInvalid
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.broken {
+		// When a channel is marked as broken the all closes
+		// must return a BrokenChannelError.
+		return c.brokenError()
+	}
+
+	if c.deliveredEnd {
+		return nil
+	}
+
+	for c.blockWrite() {
+		c.cndWrite.Wait()
+	}
+
+	if !c.deliveredEnd {
+		pkt := &lob.Packet{}
+		hdr := pkt.Header()
+		hdr.End, hdr.HasEnd = true, true
+		if err := c.write(pkt, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CloseRead shuts down the reading side of the channel. Buffered and future
+// packets are acknowledged and discarded. Subsequent reads return io.EOF.
+// Most callers should call Close afterwards.
+func (c *Channel) CloseRead() error {
+	if c == nil {
+		return os.ErrInvalid
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.broken {
+		// When a channel is marked as broken the all closes
+		// must return a BrokenChannelError.
+		return c.brokenError()
+	}
+
+	if c.readClosed {
+		return nil
+	}
+
+	c.readClosed = true
+	c.discardReadBuffer()
+	c.cndRead.Broadcast()
+
+	return nil
+}
+
+// discardReadBuffer drops all packets which would otherwise be available
+// to the reader.
+func (c *Channel) discardReadBuffer() {
+	for len(c.readBuffer) > 0 && c.readBuffer[0].seq == c.iSeq+1 && !c.readEnd {
+		e := c.readBuffer[0]
+		c.readPacket()
+		e.pkt.Free()
+	}
+}
+
 func (c *Channel) blockClose() bool {
 	if c.broken {
 		return false
@@ -820,7 +1055,7 @@ func (c *Channel) buildMissList() []uint32 {
 	// c.iSeq last read packet
 	// c.iSeq+1 is the next packet to be read
 	// c.iSeenSeq is the highest seq sean.
-	// c.iSeq + cReadBufferSize must be the last seq in the miss list
+	// c.iSeq + c.iWindow must be the last seq in the miss list
 
 	var (
 		miss []uint32
@@ -837,36 +1072,39 @@ func (c *Channel) buildMissList() []uint32 {
 
 		for seq < e.seq {
 			if miss == nil {
-				miss = make([]uint32, 0, cReadBufferSize)
+				miss = make([]uint32, 0, c.iWindow)
 			}
 			miss = append(miss, seq-last)
 			last = seq
 			seq++
 
 			n++
-			if n >= cReadBufferSize-1 {
+			if n >= int(c.iWindow)-1 {
 				goto ADD_HIGHEST_ACCEPTABLE_SEQ
 			}
 		}
+
+		// e.seq is buffered
+		seq++
 	}
 
 	for seq <= c.iSeenSeq {
 		if miss == nil {
-			miss = make([]uint32, 0, cReadBufferSize)
+			miss = make([]uint32, 0, c.iWindow)
 		}
 		miss = append(miss, seq-last)
 		last = seq
 		seq++
 
 		n++
-		if n >= cReadBufferSize-1 {
+		if n >= int(c.iWindow)-1 {
 			goto ADD_HIGHEST_ACCEPTABLE_SEQ
 		}
 	}
 
 ADD_HIGHEST_ACCEPTABLE_SEQ:
 	if n > 0 {
-		miss = append(miss, c.iSeq+cReadBufferSize-last)
+		miss = append(miss, c.iSeq+c.iWindow-last)
 	}
 
 	return miss
@@ -874,12 +1112,23 @@ ADD_HIGHEST_ACCEPTABLE_SEQ:
 
 func (c *Channel) processMissingPackets(ack uint32, miss []uint32) {
 	var (
-		omiss     = c.buildMissList()
-		now       = time.Now()
-		oneSecAgo = now.Add(-1 * time.Second)
-		last      = ack
+		omiss  = c.buildMissList()
+		now    = time.Now()
+		rtoAgo = now.Add(-c.rtt.BaseRTO())
+		last   = ack
+		lost   bool
 	)
 
+	if c.abandonExpiredPackets(now) {
+		// tell the peer to skip the abandoned packets
+		c.deliverAck()
+	}
+
+	// the last entry is the highest seq the peer is able to accept.
+	if len(miss) > 0 {
+		miss = miss[:len(miss)-1]
+	}
+
 	for _, delta := range miss {
 		seq := last + delta
 		last = seq
@@ -889,7 +1138,16 @@ func (c *Channel) processMissingPackets(ack uint32, miss []uint32) {
 			continue
 		}
 
-		if e.lastResend.After(oneSecAgo) {
+		if !lost {
+			lost = true
+			if c.cc != nil && c.oAckedSeq >= c.recoverSeq {
+				// only react once per window
+				c.cc.OnLoss()
+				c.recoverSeq = c.oSeq
+			}
+		}
+
+		if e.lastResend.After(rtoAgo) || c.isExpired(seq, e, now) {
 			continue
 		}
 
@@ -901,32 +1159,60 @@ func (c *Channel) processMissingPackets(ack uint32, miss []uint32) {
 			hdr.Miss, hdr.HasMiss = omiss, true
 		}
 		e.lastResend = now
+		e.resends++
 
 		err := c.x.deliverPacket(e.pkt, e.dst)
 		if err == nil {
 			statChannelSndPkt.Add(1)
+			c.stats.retransmitted()
 		}
 	}
 }
 
-func (c *Channel) resendLastPacket() {
+// resendOldestPacket is called by the retransmission timer. When no progress
+// was made for a full period the oldest unacknowledged packet is sent again.
+func (c *Channel) resendOldestPacket() {
 	c.mtx.Lock()
 
+	if c.abandonExpiredPackets(time.Now()) || c.oSkipSeq > c.oAckedSeq {
+		// tell the peer to skip the abandoned packets
+		c.deliverAck()
+	}
+
 	var needsResend bool
 	needsResend, c.needsResend = c.needsResend, true
-	c.tResend.Reset(1 * time.Second)
 
 	if !needsResend {
+		c.tResend.Reset(c.resendDelay())
 		c.mtx.Unlock()
 		return
 	}
 
-	e := c.writeBuffer[c.oSeq]
+	e := c.writeBuffer[c.oldestUnackedSeq()]
+	if e == nil {
+		e = c.writeBuffer[c.oSeq]
+	}
 	if e == nil {
+		c.tResend.Reset(c.resendDelay())
+		c.mtx.Unlock()
+		return
+	}
+
+	if c.isPartiallyReliable() && !c.isResendDue(e) {
+		// the timer fired early for an expiring packet (see resendDelay)
+		c.tResend.Reset(c.resendDelay())
 		c.mtx.Unlock()
 		return
 	}
 
+	c.rtt.Backoff()
+	c.tResend.Reset(c.resendDelay())
+
+	if c.cc != nil {
+		c.cc.OnTimeout()
+		c.recoverSeq = c.oSeq
+	}
+
 	omiss := c.buildMissList()
 	hdr := e.pkt.Header()
 	if c.iSeq >= cInitialSeq {
@@ -936,12 +1222,15 @@ func (c *Channel) resendLastPacket() {
 		hdr.Miss, hdr.HasMiss = omiss, true
 	}
 	e.lastResend = time.Now()
-	c.mtx.Unlock()
+	e.resends++
 
+	// deliver while locked; an ack may otherwise free the packet.
 	err := c.x.deliverPacket(e.pkt, e.dst)
 	if err == nil {
 		statChannelSndPkt.Add(1)
+		c.stats.retransmitted()
 	}
+	c.mtx.Unlock()
 }
 
 func (c *Channel) maybeDeliverAdHocAck() {
@@ -958,10 +1247,34 @@ func (c *Channel) maybeDeliverAdHocAck() {
 	}
 }
 
+// maybeScheduleAck makes sure unacknowledged reads (and missing packets) are
+// acked soon, even when the channel doesn't write any packets. Without this the
+// congestion window of the peer would not be able to grow.
+func (c *Channel) maybeScheduleAck(force bool) {
+	if !c.reliable || c.ackScheduled {
+		return
+	}
+
+	if !force && c.iSeq <= c.iAckedSeq {
+		return // nothing to ack
+	}
+
+	if c.serverside && c.oSeq == cBlankSeq {
+		// The first packet written by a server channel acks the initial
+		// packet. An earlier ack would unblock the writes of the client
+		// while the server still defers its reads.
+		return
+	}
+
+	c.ackScheduled = true
+	c.tAcker.Reset(c.rtt.AckDelay())
+}
+
 func (c *Channel) autoDeliverAck() {
 	c.mtx.Lock()
 	defer c.mtx.Unlock()
 
+	c.ackScheduled = false
 	c.deliverAck()
 	c.tAcker.Reset(10 * time.Second)
 }
@@ -974,8 +1287,13 @@ func (c *Channel) deliverAck() {
 	pkt := &lob.Packet{}
 	hdr := pkt.Header()
 	hdr.C, hdr.HasC = c.id, true
+	acked := c.iAckedSeq
 	c.applyAckHeaders(pkt)
 	err := c.x.deliverPacket(pkt, nil)
+	if err == ErrSendQueueFull {
+		// the ack was dropped; it must be sent again
+		c.iAckedSeq = acked
+	}
 	if err == nil {
 		statChannelSndAckAdHoc.Add(1)
 	}
@@ -986,6 +1304,8 @@ func (c *Channel) applyAckHeaders(pkt *lob.Packet) {
 		return
 	}
 
+	c.applySkipHeader(pkt)
+
 	if c.iSeq == cBlankSeq {
 		// nothin to ack
 		return
@@ -998,6 +1318,7 @@ func (c *Channel) applyAckHeaders(pkt *lob.Packet) {
 	if l := c.buildMissList(); len(l) > 0 {
 		hdr.Miss, hdr.HasMiss = l, true
 	}
+	c.applyWindowHeader(pkt)
 
 	c.iAckedSeq = c.iSeq
 }
@@ -1272,14 +1593,21 @@ func (c *Channel) onWriteDeadlineReached() {
 	c.mtx.Unlock()
 }
 
-// LocalAddr returns the local network address.
+// LocalAddr returns the local network address (an *Addr; use its Hashname
+// field where a hashname.H was expected). The hashname is empty when the
+// channel has no exchange.
 func (c *Channel) LocalAddr() net.Addr {
-	return c.Exchange().localIdent.Hashname()
+	addr := &Addr{Type: c.typ}
+	if x := c.Exchange(); x != nil {
+		addr.Hashname = x.localIdent.Hashname()
+	}
+	return addr
 }
 
-// RemoteAddr returns the remote network address.
+// RemoteAddr returns the remote network address (an *Addr; use its Hashname
+// field where a hashname.H was expected).
 func (c *Channel) RemoteAddr() net.Addr {
-	return c.RemoteHashname()
+	return &Addr{Hashname: c.RemoteHashname(), Type: c.typ}
 }
 
 type readBufferSlice []*readBufferEntry
@@ -1291,7 +1619,7 @@ func (s readBufferSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s readBufferSlice) IndexOf(seq uint32) int {
 	l := len(s)
 	idx := sort.Search(l, func(i int) bool { return s[i].seq >= seq })
-	if idx == l {
+	if idx == l || s[idx].seq != seq {
 		return -1
 	}
 	return idx
@@ -0,0 +1,106 @@
+package e3x
+
+// CongestionController decides how many unacknowledged packets a reliable
+// channel may have in flight. A controller is owned by a single channel and
+// is only called while the channel is locked.
+type CongestionController interface {
+	// Window returns the number of packets that may be in flight.
+	Window() int
+
+	// OnAck is called when n previously unacknowledged packets were acknowledged.
+	OnAck(n int)
+
+	// OnLoss is called (at most once per window) when the peer reported missing packets.
+	OnLoss()
+
+	// OnTimeout is called when the retransmission timer expired without progress.
+	OnTimeout()
+}
+
+const (
+	cMinCongestionWindow     = 2
+	cInitialCongestionWindow = 10
+)
+
+// CongestionControl sets the congestion controller used by a reliable channel.
+// f is called once for the channel. Unreliable channels ignore this option.
+func CongestionControl(f func() CongestionController) ChannelOption {
+	return func(c *Channel) error {
+		if c.reliable && f != nil {
+			c.cc = f()
+		}
+		return nil
+	}
+}
+
+// NewRenoController returns a CongestionController which implements
+// slow start followed by additive-increase/multiplicative-decrease.
+// This is the default controller for reliable channels.
+func NewRenoController() CongestionController {
+	return &renoController{
+		cwnd:     cInitialCongestionWindow,
+		ssthresh: cWriteBufferSize,
+	}
+}
+
+// NewFixedWindowController returns a CongestionController which always allows
+// n packets to be in flight.
+func NewFixedWindowController(n int) CongestionController {
+	if n < 1 {
+		n = 1
+	}
+	if n > cWriteBufferSize {
+		n = cWriteBufferSize
+	}
+	return fixedWindowController(n)
+}
+
+type renoController struct {
+	cwnd     float64
+	ssthresh float64
+}
+
+func (r *renoController) Window() int {
+	return int(r.cwnd)
+}
+
+func (r *renoController) OnAck(n int) {
+	if n <= 0 {
+		return
+	}
+
+	if r.cwnd < r.ssthresh {
+		// slow start
+		r.cwnd += float64(n)
+	} else {
+		// congestion avoidance
+		r.cwnd += float64(n) / r.cwnd
+	}
+
+	if r.cwnd > cWriteBufferSize {
+		r.cwnd = cWriteBufferSize
+	}
+}
+
+func (r *renoController) OnLoss() {
+	r.ssthresh = r.cwnd / 2
+	if r.ssthresh < cMinCongestionWindow {
+		r.ssthresh = cMinCongestionWindow
+	}
+	r.cwnd = r.ssthresh
+}
+
+func (r *renoController) OnTimeout() {
+	r.ssthresh = r.cwnd / 2
+	if r.ssthresh < cMinCongestionWindow {
+		r.ssthresh = cMinCongestionWindow
+	}
+	r.cwnd = cMinCongestionWindow
+}
+
+type fixedWindowController int
+
+func (f fixedWindowController) Window() int { return int(f) }
+func (f fixedWindowController) OnAck(n int) {}
+func (f fixedWindowController) OnLoss()     {}
+func (f fixedWindowController) OnTimeout()  {}
@@ -0,0 +1,168 @@
+package e3x
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestRenoController(t *testing.T) {
+	assert := assert.New(t)
+
+	cc := NewRenoController()
+	assert.Equal(cInitialCongestionWindow, cc.Window())
+
+	// slow start doubles the window each round trip
+	cc.OnAck(cInitialCongestionWindow)
+	assert.Equal(2*cInitialCongestionWindow, cc.Window())
+
+	// never exceed the write buffer
+	cc.OnAck(10 * cWriteBufferSize)
+	assert.Equal(cWriteBufferSize, cc.Window())
+
+	// multiplicative decrease
+	cc.OnLoss()
+	assert.Equal(cWriteBufferSize/2, cc.Window())
+
+	// additive increase
+	cc.OnAck(cWriteBufferSize / 2)
+	assert.Equal(cWriteBufferSize/2+1, cc.Window())
+
+	cc.OnTimeout()
+	assert.Equal(cMinCongestionWindow, cc.Window())
+}
+
+func TestCongestionControlThroughput(t *testing.T) {
+	testCongestionControl(t, 0, 2000)
+}
+
+func TestCongestionControlLossy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("this is a long running test.")
+	}
+
+	testCongestionControl(t, 0.05, 2000)
+}
+
+func testCongestionControl(t *testing.T, loss float64, n int) {
+	withTwoLossyEndpoints(t, loss, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("flood", true).AcceptChannel()
+			if !assert.NoError(err) || !assert.NotNil(c) {
+				return
+			}
+			defer c.Close()
+
+			c.SetDeadline(time.Now().Add(30 * time.Second))
+
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+			pkt.Free()
+
+			for i := 0; i < n; i++ {
+				pkt := lob.New(make([]byte, 1000))
+				pkt.Header().SetInt("flood_id", i)
+				if !assert.NoError(c.WritePacket(pkt)) {
+					return
+				}
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		start := time.Now()
+
+		c, err := B.Open(ident, "flood", true)
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(30 * time.Second))
+
+		assert.NoError(c.WritePacket(lob.New(nil)))
+
+		lastID := -1
+		for {
+			pkt, err := c.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(err) {
+				break
+			}
+			id, _ := pkt.Header().GetInt("flood_id")
+			assert.Equal(lastID+1, id)
+			lastID = id
+			pkt.Free()
+		}
+
+		d := time.Since(start)
+		assert.Equal(n-1, lastID)
+		assert.NoError(c.Close())
+		<-done
+
+		t.Logf("loss=%.2f packets=%d duration=%s throughput=%.0fKiB/s", loss, n, d, float64(n*1000)/1024/d.Seconds())
+	})
+}
+
+func TestOpenHandshakeBlocksWrites(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		assert := assert.New(t)
+
+		l := A.Listen("handshake", true)
+		defer l.Close()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "handshake", true)
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Close()
+
+		assert.NoError(c.WritePacket(lob.New(nil)))
+
+		d, err := l.AcceptChannel()
+		if !assert.NoError(err) {
+			return
+		}
+		d.SetDeadline(time.Now().Add(5 * time.Second))
+
+		_, err = d.ReadPacket()
+		assert.NoError(err)
+
+		// the second write waits until the server responded
+		c.SetWriteDeadline(time.Now().Add(500 * time.Millisecond))
+		assert.Equal(ErrTimeout, c.WritePacket(lob.New(nil)))
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+
+		assert.NoError(d.WritePacket(lob.New(nil)))
+
+		_, err = c.ReadPacket()
+		assert.NoError(err)
+		for i := 0; i < 5; i++ {
+			assert.NoError(c.WritePacket(lob.New(nil)))
+		}
+		for i := 0; i < 5; i++ {
+			_, err = d.ReadPacket()
+			assert.NoError(err)
+		}
+
+		go d.Close()
+	})
+}
@@ -0,0 +1,216 @@
+package e3x
+
+import (
+	"errors"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// ErrMessageTooLarge is returned by WriteMessage when the message exceeds
+// the maximum message size of 16384000 bytes (16384 fragments of 1000 bytes).
+var ErrMessageTooLarge = errors.New("e3x: message too large")
+
+const (
+	cMessageFragmentSize  = 1000
+	cMaxMessageFragments  = 16 * 1024
+	cMaxMessageSize       = cMessageFragmentSize * cMaxMessageFragments
+	cMaxPendingMessages   = 16
+	cMaxMessageIDDistance = 1024
+)
+
+type pendingMessage struct {
+	fragments [][]byte
+	received  int
+	size      int
+}
+
+// WriteMessage writes a message of up to 16384000 bytes to the channel.
+// The message is split into fragments of 1000 bytes which are reassembled
+// by ReadMessage on the other side. Larger messages are rejected with
+// ErrMessageTooLarge. Concurrent calls to WriteMessage never interleave their
+// fragments.
+func (c *Channel) WriteMessage(b []byte) error {
+	if len(b) > cMaxMessageSize {
+		return ErrMessageTooLarge
+	}
+
+	c.wmtx.Lock()
+	defer c.wmtx.Unlock()
+
+	c.oMessageID++
+
+	var (
+		mid = c.oMessageID
+		fn  = (len(b) + cMessageFragmentSize - 1) / cMessageFragmentSize
+	)
+
+	if fn == 0 {
+		fn = 1
+	}
+
+	for fi := 0; fi < fn; fi++ {
+		chunk := b
+		if len(chunk) > cMessageFragmentSize {
+			chunk = chunk[:cMessageFragmentSize]
+		}
+		b = b[len(chunk):]
+
+		pkt := lob.New(chunk)
+		hdr := pkt.Header()
+		hdr.SetUint32("mid", mid)
+		hdr.SetInt("fi", fi)
+		hdr.SetInt("fn", fn)
+
+		if err := c.WritePacket(pkt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadMessage reads the next message written with WriteMessage. Packets
+// which are not part of a message are returned as a message of their own
+// (including empty packets).
+// On unreliable channels incomplete messages are discarded when too many
+// newer messages are pending.
+//
+// The peer of a server channel can't send more than one packet before the
+// server channel responds. When the first message is split into fragments,
+// ReadMessage writes an empty packet with the opened header to complete the
+// open handshake. ReadMessage skips these packets on the other side.
+func (c *Channel) ReadMessage() ([]byte, error) {
+	c.rmtx.Lock()
+	defer c.rmtx.Unlock()
+
+	for {
+		pkt, err := c.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		msg, done := c.reassembleMessage(pkt)
+		pkt.Free()
+		if done {
+			return msg, nil
+		}
+
+		if err := c.respondToOpen(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// respondToOpen writes an empty opened packet when the channel is a server
+// channel which did not yet write any packets.
+func (c *Channel) respondToOpen() error {
+	c.mtx.Lock()
+	pending := c.serverside && c.oSeq == cBlankSeq
+	c.mtx.Unlock()
+
+	if !pending {
+		return nil
+	}
+
+	pkt := lob.New(nil)
+	pkt.Header().SetBool("opened", true)
+	return c.WritePacket(pkt)
+}
+
+func (c *Channel) reassembleMessage(pkt *lob.Packet) ([]byte, bool) {
+	var (
+		hdr         = pkt.Header()
+		mid, hasMid = hdr.GetUint32("mid")
+		fi, hasFi   = hdr.GetInt("fi")
+		fn, hasFn   = hdr.GetInt("fn")
+	)
+
+	if !hasMid && !hasFi && !hasFn {
+		if opened, _ := hdr.GetBool("opened"); opened && pkt.BodyLen() == 0 {
+			// written by respondToOpen
+			return nil, false
+		}
+
+		// a plain packet
+		body := pkt.Body(nil)
+		if body == nil {
+			body = []byte{}
+		}
+		return body, true
+	}
+
+	if !hasMid || !hasFi || !hasFn || fn < 1 || fn > cMaxMessageFragments || fi < 0 || fi >= fn {
+		// drop: invalid fragment
+		c.dropPacket(pkt, ErrInvalidFragment)
+		return nil, false
+	}
+
+	if fn == 1 {
+		return pkt.Body(nil), true
+	}
+
+	if c.iMessages == nil {
+		c.iMessages = make(map[uint32]*pendingMessage)
+	}
+
+	m := c.iMessages[mid]
+	if m == nil {
+		if mid <= c.iMessageID && c.iMessageID-mid < cMaxMessageIDDistance {
+			// drop: message was already delivered or discarded
+			c.dropPacket(pkt, ErrDuplicatePacket)
+			return nil, false
+		}
+		if len(c.iMessages) >= cMaxPendingMessages {
+			c.discardOldestMessage()
+		}
+		m = &pendingMessage{fragments: make([][]byte, fn)}
+		c.iMessages[mid] = m
+	}
+
+	if len(m.fragments) != fn || m.fragments[fi] != nil {
+		// drop: conflicting or duplicate fragment
+		c.dropPacket(pkt, ErrDuplicatePacket)
+		return nil, false
+	}
+
+	body := pkt.Body(nil)
+	if body == nil {
+		body = []byte{}
+	}
+	m.fragments[fi] = body
+	m.received++
+	m.size += len(body)
+
+	if m.received < fn {
+		return nil, false
+	}
+
+	delete(c.iMessages, mid)
+	if c.iMessageID < mid {
+		c.iMessageID = mid
+	}
+
+	msg := make([]byte, 0, m.size)
+	for _, f := range m.fragments {
+		msg = append(msg, f...)
+	}
+	return msg, true
+}
+
+func (c *Channel) discardOldestMessage() {
+	var (
+		mid   uint32
+		found bool
+	)
+
+	for id := range c.iMessages {
+		if !found || id < mid {
+			mid, found = id, true
+		}
+	}
+
+	delete(c.iMessages, mid)
+	if c.iMessageID < mid {
+		c.iMessageID = mid
+	}
+}
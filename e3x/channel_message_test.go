@@ -0,0 +1,151 @@
+package e3x
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestMessageReassembly(t *testing.T) {
+	assert := assert.New(t)
+
+	fragment := func(mid uint32, fi, fn int, body string) *lob.Packet {
+		pkt := lob.New([]byte(body))
+		pkt.Header().SetUint32("mid", mid)
+		pkt.Header().SetInt("fi", fi)
+		pkt.Header().SetInt("fn", fn)
+		return pkt
+	}
+
+	c := &Channel{}
+
+	// out of order
+	msg, done := c.reassembleMessage(fragment(1, 2, 3, "c"))
+	assert.False(done)
+	msg, done = c.reassembleMessage(fragment(1, 0, 3, "a"))
+	assert.False(done)
+	msg, done = c.reassembleMessage(fragment(1, 0, 3, "a"))
+	assert.False(done)
+	msg, done = c.reassembleMessage(fragment(1, 1, 3, "b"))
+	assert.True(done)
+	assert.Equal("abc", string(msg))
+
+	// late fragments of a delivered message are dropped
+	msg, done = c.reassembleMessage(fragment(1, 1, 3, "b"))
+	assert.False(done)
+	assert.Equal(0, len(c.iMessages))
+
+	// invalid fragments are dropped
+	msg, done = c.reassembleMessage(fragment(2, 3, 3, "x"))
+	assert.False(done)
+
+	// plain packets are messages
+	msg, done = c.reassembleMessage(lob.New([]byte("plain")))
+	assert.True(done)
+	assert.Equal("plain", string(msg))
+
+	// empty packets are messages
+	msg, done = c.reassembleMessage(lob.New(nil))
+	assert.True(done)
+	assert.NotNil(msg)
+	assert.Equal(0, len(msg))
+
+	// opened packets only complete the open handshake
+	opened := lob.New(nil)
+	opened.Header().SetBool("opened", true)
+	msg, done = c.reassembleMessage(opened)
+	assert.False(done)
+
+	// incomplete messages are discarded
+	for i := 0; i <= cMaxPendingMessages; i++ {
+		c.reassembleMessage(fragment(uint32(10+i), 0, 2, "x"))
+	}
+	assert.Equal(cMaxPendingMessages, len(c.iMessages))
+	_, found := c.iMessages[10]
+	assert.False(found)
+}
+
+func TestWriteReadMessage(t *testing.T) {
+	testWriteReadMessage(t, true)
+}
+
+func TestWriteReadMessageUnreliable(t *testing.T) {
+	testWriteReadMessage(t, false)
+}
+
+func testWriteReadMessage(t *testing.T, reliable bool) {
+	var messages = [][]byte{
+		bytes.Repeat([]byte{'a'}, 100*1024),
+		[]byte("hello"),
+		[]byte{},
+		bytes.Repeat([]byte{'b'}, cMessageFragmentSize),
+		bytes.Repeat([]byte{'c'}, cMessageFragmentSize+1),
+	}
+
+	if !reliable {
+		// keep the burst small enough for the read buffer
+		messages[0] = messages[0][:20*1024]
+	}
+
+	withTwoLossyEndpoints(t, 0, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("msg", reliable).AcceptChannel()
+			if !assert.NoError(err) || !assert.NotNil(c) {
+				return
+			}
+			defer c.Close()
+
+			c.SetDeadline(time.Now().Add(10 * time.Second))
+
+			for _, expected := range messages {
+				msg, err := c.ReadMessage()
+				if !assert.NoError(err) {
+					return
+				}
+				assert.Equal(len(expected), len(msg))
+				assert.True(bytes.Equal(expected, msg))
+			}
+
+			// a plain empty packet is an empty message
+			msg, err := c.ReadMessage()
+			if !assert.NoError(err) {
+				return
+			}
+			assert.Equal(0, len(msg))
+
+			assert.NoError(c.WriteMessage([]byte("done")))
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "msg", reliable)
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		for _, msg := range messages {
+			assert.NoError(c.WriteMessage(msg))
+		}
+		assert.NoError(c.WritePacket(lob.New(nil)))
+
+		msg, err := c.ReadMessage()
+		assert.NoError(err)
+		assert.Equal("done", string(msg))
+		assert.NoError(c.Close())
+		<-done
+	})
+}
@@ -0,0 +1,66 @@
+package e3x
+
+import (
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestReadBufferIndexOf(t *testing.T) {
+	assert := assert.New(t)
+
+	s := readBufferSlice{{seq: 1}, {seq: 3}}
+	assert.Equal(0, s.IndexOf(1))
+	assert.Equal(1, s.IndexOf(3))
+
+	// seqs which are not buffered are not found
+	assert.Equal(-1, s.IndexOf(2))
+	assert.Equal(-1, s.IndexOf(4))
+}
+
+func TestBuildMissList(t *testing.T) {
+	assert := assert.New(t)
+
+	x := &MockExchange{}
+	c := newChannel("a", "miss", true, false, x)
+	defer c.unsetTimers()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// read 1, buffered 3 and 5
+	c.iSeq = 1
+	c.iSeenSeq = 5
+	c.readBuffer = readBufferSlice{{seq: 3}, {seq: 5}}
+
+	// 2 and 4 are missing; the last entry is the highest acceptable seq
+	miss := c.buildMissList()
+	if assert.Equal(3, len(miss)) {
+		assert.Equal([]uint32{1, 2}, miss[:2])
+	}
+}
+
+func TestProcessMissingPackets(t *testing.T) {
+	x := &MockExchange{}
+	x.On("deliverPacket", mock.Anything).Return(nil)
+
+	c := newChannel("a", "miss", true, false, x)
+	defer c.unsetTimers()
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, seq := range []uint32{2, 101} {
+		pkt := lob.New(nil)
+		hdr := pkt.Header()
+		hdr.Seq, hdr.HasSeq = seq, true
+		c.writeBuffer[seq] = &writeBufferEntry{pkt: pkt}
+	}
+
+	// 2 is missing; 101 is the highest seq the peer is able to accept
+	c.processMissingPackets(1, []uint32{1, 99})
+	x.AssertNumberOfCalls(t, "deliverPacket", 1)
+}
@@ -0,0 +1,204 @@
+package e3x
+
+import (
+	"io"
+	"runtime/debug"
+	"sync"
+
+	"github.com/telehash/gogotelehash/internal/util/logs"
+)
+
+// Handler serves an incoming channel. The handler owns the channel and must
+// close it when it is done.
+type Handler interface {
+	ServeChannel(c *Channel)
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(c *Channel)
+
+// ServeChannel calls f(c).
+func (f HandlerFunc) ServeChannel(c *Channel) { f(c) }
+
+// Middleware wraps a Handler (for example to authorize the peer or to log
+// channels).
+type Middleware func(next Handler) Handler
+
+// HandleOption configures a route of a ChannelMux.
+type HandleOption func(r *muxRoute)
+
+// MaxConcurrent limits the number of channels which are served at the same
+// time. Further channels wait in the backlog of the listener (see MaxPending).
+func MaxConcurrent(n int) HandleOption {
+	return func(r *muxRoute) {
+		if n > 0 {
+			r.sem = make(chan struct{}, n)
+		}
+	}
+}
+
+// ListenerOptions sets the options of the listener of a route.
+func ListenerOptions(options ...ListenerOption) HandleOption {
+	return func(r *muxRoute) {
+		r.listenerOptions = append(r.listenerOptions, options...)
+	}
+}
+
+// ChannelMux dispatches incoming channels to handlers by channel type. It
+// replaces the accept loop every module used to write. A pattern is either a
+// channel type, a prefix ending in "*" or "*" which matches every type. The
+// exact type is preferred, otherwise the longest prefix wins. Routes are
+// registered per reliability: a channel only matches the routes with the same
+// reliability and a pattern can have both a reliable and an unreliable route.
+//
+// Every endpoint has a ChannelMux; see ChannelMuxFromEndpoint.
+type ChannelMux struct {
+	e *Endpoint
+
+	mtx        sync.Mutex
+	middleware []Middleware
+	routes     map[listenerKey]*muxRoute
+}
+
+type muxRoute struct {
+	pattern         string
+	handler         Handler
+	listener        *Listener
+	listenerOptions []ListenerOption
+	sem             chan struct{}
+}
+
+// ChannelMuxFromEndpoint returns the ChannelMux of e.
+func ChannelMuxFromEndpoint(e *Endpoint) *ChannelMux {
+	mod := e.Module(modChannelMuxKey)
+	if mod == nil {
+		return nil
+	}
+	return mod.(*ChannelMux)
+}
+
+const modChannelMuxKey = pivateModKey("channel mux")
+
+func (m *ChannelMux) Init() error  { return nil }
+func (m *ChannelMux) Start() error { return nil }
+
+// Stop closes the listeners of all routes. Channels which are being served
+// are not affected.
+func (m *ChannelMux) Stop() error {
+	m.mtx.Lock()
+	routes := m.routes
+	m.routes = nil
+	m.mtx.Unlock()
+
+	for _, r := range routes {
+		r.listener.Close()
+	}
+	return nil
+}
+
+// Use adds middleware to all routes. Middleware is applied in the order it
+// was added; the first middleware sees the channel first.
+func (m *ChannelMux) Use(middleware ...Middleware) {
+	m.mtx.Lock()
+	m.middleware = append(m.middleware, middleware...)
+	m.mtx.Unlock()
+}
+
+// Handle registers the handler for the pattern. It panics when the pattern is
+// already registered with the same reliability.
+func (m *ChannelMux) Handle(pattern string, reliable bool, handler Handler, options ...HandleOption) {
+	r := &muxRoute{pattern: pattern, handler: handler}
+	for _, option := range options {
+		option(r)
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	key := listenerKey{pattern, reliable}
+	if _, found := m.routes[key]; found {
+		panic("e3x: channel handler is already registered: " + pattern)
+	}
+	if m.routes == nil {
+		m.routes = make(map[listenerKey]*muxRoute)
+	}
+
+	r.listener = m.e.Listen(pattern, reliable, r.listenerOptions...)
+	m.routes[key] = r
+
+	go m.accept(r)
+}
+
+// HandleFunc registers the handler function for the pattern.
+func (m *ChannelMux) HandleFunc(pattern string, reliable bool, handler func(c *Channel), options ...HandleOption) {
+	m.Handle(pattern, reliable, HandlerFunc(handler), options...)
+}
+
+// Remove unregisters the handler of the pattern with the given reliability.
+// Channels which are being served are not affected.
+func (m *ChannelMux) Remove(pattern string, reliable bool) {
+	key := listenerKey{pattern, reliable}
+
+	m.mtx.Lock()
+	r := m.routes[key]
+	delete(m.routes, key)
+	m.mtx.Unlock()
+
+	if r != nil {
+		r.listener.Close()
+	}
+}
+
+func (m *ChannelMux) accept(r *muxRoute) {
+	for {
+		if r.sem != nil {
+			r.sem <- struct{}{}
+		}
+
+		c, err := r.listener.AcceptChannel()
+		if err != nil {
+			if r.sem != nil {
+				<-r.sem
+			}
+			if err == io.EOF {
+				return
+			}
+			continue
+		}
+
+		go m.serve(r, c)
+	}
+}
+
+func (m *ChannelMux) serve(r *muxRoute, c *Channel) {
+	if r.sem != nil {
+		defer func() { <-r.sem }()
+	}
+
+	m.mtx.Lock()
+	h := r.handler
+	for i := len(m.middleware) - 1; i >= 0; i-- {
+		h = m.middleware[i](h)
+	}
+	m.mtx.Unlock()
+
+	h.ServeChannel(c)
+}
+
+// Recover is a middleware which kills the channel when the handler panics
+// instead of crashing the endpoint. The panic is logged to log (which may be
+// nil).
+func Recover(log *logs.Logger) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(c *Channel) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("\x1B[31mPanic in channel handler\x1B[0m %q: %v\n%s", c.typ, r, debug.Stack())
+					c.Kill()
+				}
+			}()
+
+			next.ServeChannel(c)
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package e3x
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestMatchType(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(matchType("path", "path"))
+	assert.False(matchType("path", "paths"))
+	assert.True(matchType("thtp/*", "thtp/get"))
+	assert.False(matchType("thtp/*", "thtp"))
+	assert.True(matchType("*", "anything"))
+}
+
+func TestChannelMux(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		assert := assert.New(t)
+
+		var (
+			mtx   sync.Mutex
+			trace []string
+		)
+
+		record := func(s string) {
+			mtx.Lock()
+			trace = append(trace, s)
+			mtx.Unlock()
+		}
+
+		reply := func(name string) func(c *Channel) {
+			return func(c *Channel) {
+				defer c.Close()
+
+				c.SetDeadline(time.Now().Add(5 * time.Second))
+				if _, err := c.ReadPacket(); err != nil {
+					return
+				}
+				record(name)
+				c.WritePacket(lob.New([]byte(name)))
+			}
+		}
+
+		mux := ChannelMuxFromEndpoint(A)
+		mux.Use(func(next Handler) Handler {
+			return HandlerFunc(func(c *Channel) {
+				record("first " + c.typ)
+				next.ServeChannel(c)
+			})
+		}, func(next Handler) Handler {
+			return HandlerFunc(func(c *Channel) {
+				record("second " + c.typ)
+				next.ServeChannel(c)
+			})
+		})
+		mux.HandleFunc("app", true, reply("exact"))
+		mux.HandleFunc("app/*", true, reply("prefix"))
+		mux.HandleFunc("app/admin/*", true, reply("longest prefix"))
+		mux.HandleFunc("*", true, reply("wildcard"))
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		call := func(typ string) string {
+			c, err := B.Open(ident, typ, true)
+			if !assert.NoError(err) {
+				return ""
+			}
+			defer c.Close()
+
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+			assert.NoError(c.WritePacket(lob.New(nil)))
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return ""
+			}
+			return string(pkt.Body(nil))
+		}
+
+		assert.Equal("exact", call("app"))
+		assert.Equal("prefix", call("app/x"))
+		assert.Equal("longest prefix", call("app/admin/x"))
+		assert.Equal("wildcard", call("other"))
+
+		mtx.Lock()
+		assert.Equal([]string{"first app", "second app", "exact"}, trace[:3])
+		mtx.Unlock()
+
+		// removed routes fall back to the next pattern
+		mux.Remove("app/*", true)
+		assert.Equal("wildcard", call("app/x"))
+	})
+}
+
+func TestChannelMuxReliability(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		assert := assert.New(t)
+
+		reply := func(name string) func(c *Channel) {
+			return func(c *Channel) {
+				defer c.Close()
+
+				c.SetDeadline(time.Now().Add(5 * time.Second))
+				if _, err := c.ReadPacket(); err != nil {
+					return
+				}
+				c.WritePacket(lob.New([]byte(name)))
+			}
+		}
+
+		mux := ChannelMuxFromEndpoint(A)
+		mux.HandleFunc("echo", true, reply("reliable echo"))
+		mux.HandleFunc("echo", false, reply("unreliable echo"))
+		mux.HandleFunc("app/*", false, reply("unreliable prefix"))
+		mux.HandleFunc("*", true, reply("reliable wildcard"))
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		call := func(typ string, reliable bool) string {
+			c, err := B.Open(ident, typ, reliable)
+			if !assert.NoError(err) {
+				return ""
+			}
+			defer c.Close()
+
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+			assert.NoError(c.WritePacket(lob.New(nil)))
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return ""
+			}
+			return string(pkt.Body(nil))
+		}
+
+		assert.Equal("reliable echo", call("echo", true))
+		assert.Equal("unreliable echo", call("echo", false))
+		assert.Equal("unreliable prefix", call("app/x", false))
+
+		// a route of the other reliability is skipped
+		assert.Equal("reliable wildcard", call("app/x", true))
+	})
+}
+
+func TestChannelMuxMaxConcurrent(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		assert := assert.New(t)
+
+		var (
+			mtx     sync.Mutex
+			active  int
+			maxSeen int
+			release = make(chan struct{})
+			served  = make(chan struct{}, 3)
+		)
+
+		ChannelMuxFromEndpoint(A).HandleFunc("limited", true, func(c *Channel) {
+			defer func() { go c.Close() }()
+
+			mtx.Lock()
+			active++
+			if active > maxSeen {
+				maxSeen = active
+			}
+			mtx.Unlock()
+
+			c.ReadPacket()
+			served <- struct{}{}
+			<-release
+
+			mtx.Lock()
+			active--
+			mtx.Unlock()
+		}, MaxConcurrent(1))
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		for i := 0; i < 3; i++ {
+			c, err := B.Open(ident, "limited", true)
+			if !assert.NoError(err) {
+				return
+			}
+			defer c.Close()
+
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+			assert.NoError(c.WritePacket(lob.New(nil)))
+		}
+
+		for i := 0; i < 3; i++ {
+			select {
+			case <-served:
+			case <-time.After(5 * time.Second):
+				t.Fatal("channel was not served")
+			}
+
+			// the next channel waits until this one is done
+			select {
+			case <-served:
+				t.Fatal("channels were served concurrently")
+			case <-time.After(50 * time.Millisecond):
+			}
+			release <- struct{}{}
+		}
+
+		mtx.Lock()
+		assert.Equal(1, maxSeen)
+		mtx.Unlock()
+	})
+}
+
+func TestChannelMuxRecover(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		assert := assert.New(t)
+
+		mux := ChannelMuxFromEndpoint(A)
+		mux.Use(Recover(nil))
+		mux.HandleFunc("panic", true, func(c *Channel) {
+			c.ReadPacket()
+			panic("boom")
+		})
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "panic", true)
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Kill()
+
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		assert.NoError(c.WritePacket(lob.New(nil)))
+
+		// the channel is killed but the endpoint keeps running
+		x := A.GetExchange(B.LocalHashname())
+		if assert.NotNil(x) {
+			deadline := time.Now().Add(5 * time.Second)
+			for x.channels.Len() > 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.Equal(0, x.channels.Len())
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package e3x
+
+import (
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// PartialReliability makes a reliable channel ordered but lossy (like
+// PR-SCTP). A packet which was not acknowledged within lifetime, or which was
+// retransmitted maxRetransmits times, is abandoned and the peer is told to
+// skip it (with the "skip" header) instead of blocking its reader. A zero
+// value disables the respective limit. The initial packet and the "end"
+// packet are never abandoned. The option has no effect on unreliable
+// channels.
+func PartialReliability(lifetime time.Duration, maxRetransmits int) ChannelOption {
+	return func(c *Channel) error {
+		if lifetime < 0 {
+			lifetime = 0
+		}
+		if maxRetransmits < 0 {
+			maxRetransmits = 0
+		}
+		c.prLifetime = lifetime
+		c.prMaxRetransmits = maxRetransmits
+		return nil
+	}
+}
+
+func (c *Channel) isPartiallyReliable() bool {
+	return c.reliable && (c.prLifetime > 0 || c.prMaxRetransmits > 0)
+}
+
+// isExpired returns true when the packet with seq may be abandoned.
+func (c *Channel) isExpired(seq uint32, e *writeBufferEntry, now time.Time) bool {
+	if !c.isPartiallyReliable() || seq == cInitialSeq || e.end {
+		return false
+	}
+
+	if c.prLifetime > 0 && now.Sub(e.sentAt) >= c.prLifetime {
+		return true
+	}
+
+	if c.prMaxRetransmits > 0 && e.resends >= c.prMaxRetransmits {
+		return true
+	}
+
+	return false
+}
+
+// abandonExpiredPackets drops the expired packets at the head of the write
+// buffer and advances the skip point. It returns true when the skip point
+// moved. The channel must be locked.
+func (c *Channel) abandonExpiredPackets(now time.Time) bool {
+	if !c.isPartiallyReliable() {
+		return false
+	}
+
+	var (
+		seq     = c.oldestUnackedSeq()
+		changed bool
+	)
+
+	for ; seq <= c.oSeq; seq++ {
+		e := c.writeBuffer[seq]
+		if e == nil || !c.isExpired(seq, e, now) {
+			break
+		}
+
+		c.stats.drop(ErrExpired.Reason)
+		c.channelHooks.DropPacket(e.pkt, ErrExpired)
+		e.pkt.Free()
+		delete(c.writeBuffer, seq)
+		c.oSkipSeq = seq
+		changed = true
+	}
+
+	if changed {
+		c.cndWrite.Signal()
+		if c.deliveredEnd || c.receivedEnd {
+			c.cndClose.Signal()
+		}
+	}
+
+	return changed
+}
+
+// resendDelay returns when the retransmission timer must fire next. On
+// partially reliable channels it fires no later than the expiry of the
+// oldest packet.
+func (c *Channel) resendDelay() time.Duration {
+	d := c.rtt.RTO()
+	if !c.isPartiallyReliable() || c.prLifetime <= 0 {
+		return d
+	}
+
+	e := c.writeBuffer[c.oldestUnackedSeq()]
+	if e == nil {
+		return d
+	}
+
+	left := c.prLifetime - time.Since(e.sentAt)
+	if left < cMinAckWait {
+		left = cMinAckWait
+	}
+	if left < d {
+		d = left
+	}
+	return d
+}
+
+// isResendDue returns true when e was (re)sent at least one RTO ago.
+func (c *Channel) isResendDue(e *writeBufferEntry) bool {
+	last := e.lastResend
+	if last.IsZero() {
+		last = e.sentAt
+	}
+	return time.Since(last) >= c.rtt.RTO()
+}
+
+// oldestUnackedSeq returns the first seq which was neither acknowledged nor
+// abandoned.
+func (c *Channel) oldestUnackedSeq() uint32 {
+	if c.oSkipSeq > c.oAckedSeq {
+		return c.oSkipSeq + 1
+	}
+	return c.oAckedSeq + 1
+}
+
+func (c *Channel) applySkipHeader(pkt *lob.Packet) {
+	if c.oSkipSeq > c.oAckedSeq {
+		// until the peer acknowledged the skip point
+		pkt.Header().SetUint32("skip", c.oSkipSeq)
+	}
+}
+
+func (c *Channel) processSkipHeader(pkt *lob.Packet) {
+	hdr := pkt.Header()
+	skip, ok := hdr.GetUint32("skip")
+	if !ok {
+		return
+	}
+	delete(hdr.Extra, "skip")
+
+	if skip > c.iSkipSeq {
+		// never skip beyond what the peer may have sent
+		if skip > c.iSeq+c.iWindow {
+			skip = c.iSeq + c.iWindow
+		}
+
+		c.iSkipSeq = skip
+		if c.iSeenSeq < skip {
+			c.iSeenSeq = skip
+		}
+
+		if c.skipAbandonedPackets() {
+			c.cndRead.Signal()
+		}
+	}
+
+	if c.iSeq >= skip {
+		// the peer repeats the skip point until it is acknowledged;
+		// it probably missed our ack.
+		c.maybeScheduleAck(true)
+	}
+}
+
+// skipAbandonedPackets moves the read stream past missing packets which were
+// abandoned by the peer. It returns true when the read stream moved. The
+// channel must be locked.
+func (c *Channel) skipAbandonedPackets() bool {
+	var changed bool
+
+	for c.iSeq < c.iSkipSeq && c.iSeq >= cInitialSeq {
+		if len(c.readBuffer) > 0 && c.readBuffer[0].seq == c.iSeq+1 {
+			// the next packet was received; it must be read first.
+			break
+		}
+
+		c.iSeq++
+		changed = true
+	}
+
+	return changed
+}
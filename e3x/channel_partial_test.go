@@ -0,0 +1,202 @@
+package e3x
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestSkipAbandonedPackets(t *testing.T) {
+	assert := assert.New(t)
+
+	x := &MockExchange{}
+	x.On("deliverPacket", mock.Anything).Return(nil)
+
+	c := newChannel("a", "partial", true, false, x)
+	defer c.unsetTimers()
+	c.SetDeadline(time.Now().Add(time.Second))
+
+	packet := func(seq uint32, skip uint32) *lob.Packet {
+		pkt := lob.New(nil)
+		hdr := pkt.Header()
+		hdr.Seq, hdr.HasSeq = seq, true
+		hdr.SetUint32("id", seq)
+		if skip > 0 {
+			hdr.SetUint32("skip", skip)
+		}
+		return pkt
+	}
+
+	assert.NoError(c.WritePacket(lob.New(nil)))
+
+	c.receivedPacket(packet(1, 0))
+	c.receivedPacket(packet(4, 0))
+	c.receivedPacket(packet(6, 3))
+
+	for _, expected := range []uint32{1, 4} {
+		pkt, err := c.ReadPacket()
+		if !assert.NoError(err) {
+			return
+		}
+		id, _ := pkt.Header().GetUint32("id")
+		assert.Equal(expected, id)
+		_, found := pkt.Header().Get("skip")
+		assert.False(found)
+	}
+
+	// 5 was not abandoned
+	c.mtx.Lock()
+	assert.True(c.blockRead())
+	c.mtx.Unlock()
+
+	c.receivedPacket(packet(7, 5))
+	pkt, err := c.ReadPacket()
+	if assert.NoError(err) {
+		id, _ := pkt.Header().GetUint32("id")
+		assert.Equal(uint32(6), id)
+	}
+
+	// late packets are dropped
+	c.receivedPacket(packet(5, 0))
+	pkt, err = c.ReadPacket()
+	if assert.NoError(err) {
+		id, _ := pkt.Header().GetUint32("id")
+		assert.Equal(uint32(7), id)
+	}
+}
+
+func TestAbandonExpiredPackets(t *testing.T) {
+	assert := assert.New(t)
+
+	x := &MockExchange{}
+	x.On("deliverPacket", mock.Anything).Return(nil)
+
+	c := newChannel("a", "partial", true, false, x, PartialReliability(0, 1))
+	defer c.unsetTimers()
+	c.SetDeadline(time.Now().Add(time.Second))
+
+	assert.NoError(c.WritePacket(lob.New(nil)))
+
+	// the response of the peer
+	pkt := lob.New(nil)
+	hdr := pkt.Header()
+	hdr.Seq, hdr.HasSeq = 1, true
+	hdr.Ack, hdr.HasAck = 1, true
+	c.receivedPacket(pkt)
+	pkt, err := c.ReadPacket()
+	if assert.NoError(err) {
+		pkt.Free()
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(c.WritePacket(lob.New(nil)))
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+
+	// nothing was retransmitted yet
+	assert.False(c.abandonExpiredPackets(now))
+
+	c.writeBuffer[3].resends = 1
+	assert.False(c.abandonExpiredPackets(now))
+
+	c.writeBuffer[2].resends = 1
+	assert.True(c.abandonExpiredPackets(now))
+	assert.Equal(uint32(3), c.oSkipSeq)
+	assert.Equal(1, len(c.writeBuffer))
+	assert.Equal(uint64(2), c.stats.snapshotDrops(nil)[ErrExpired.Reason])
+
+	pkt = &lob.Packet{}
+	c.applyAckHeaders(pkt)
+	skip, _ := pkt.Header().GetUint32("skip")
+	assert.Equal(uint32(3), skip)
+
+	// the peer acknowledged the skip point
+	c.oAckedSeq = 3
+	pkt = &lob.Packet{}
+	c.applyAckHeaders(pkt)
+	_, found := pkt.Header().Get("skip")
+	assert.False(found)
+}
+
+func TestPartialReliability(t *testing.T) {
+	const n = 100
+
+	withTwoLossyEndpoints(t, 0.1, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+			l      = A.Listen("partial", true, AcceptOptions(PartialReliability(100*time.Millisecond, 2)))
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := l.AcceptChannel()
+			if !assert.NoError(err) || !assert.NotNil(c) {
+				return
+			}
+
+			c.SetDeadline(time.Now().Add(10 * time.Second))
+
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+			pkt.Free()
+
+			for i := 0; i < n; i++ {
+				pkt := lob.New(nil)
+				pkt.Header().SetInt("id", i)
+				if !assert.NoError(c.WritePacket(pkt)) {
+					return
+				}
+			}
+
+			// don't wait for the close handshake; it is not loss tolerant.
+			assert.NoError(c.CloseWrite())
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "partial", true)
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		assert.NoError(c.WritePacket(lob.New(nil)))
+
+		var (
+			last     = -1
+			received int
+		)
+
+		for {
+			pkt, err := c.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(err) {
+				break
+			}
+			id, _ := pkt.Header().GetInt("id")
+			assert.True(id > last, "out of order: %d after %d", id, last)
+			last = id
+			received++
+			pkt.Free()
+		}
+
+		assert.True(received > 0)
+		<-done
+	})
+}
@@ -0,0 +1,185 @@
+package e3x
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// ErrCannotReject is returned by Channel.Reject when the channel was opened
+// locally or already sent a packet to its peer.
+var ErrCannotReject = errors.New("e3x: channel can no longer be rejected")
+
+// RejectCode identifies why a channel open was rejected. Codes below
+// RejectApplication are reserved for e3x.
+type RejectCode int
+
+const (
+	RejectRefused         RejectCode = 1 // the open was refused
+	RejectUnknownType     RejectCode = 2 // there is no listener for the channel type
+	RejectBacklogFull     RejectCode = 3 // the backlog of the listener is full
+	RejectInvalidType     RejectCode = 4 // the listener doesn't accept the channel (reliability mismatch)
+	RejectListenerClosed  RejectCode = 5 // the listener was closed
+	RejectTooManyChannels RejectCode = 6 // the exchange reached its channel limit
+	RejectShuttingDown    RejectCode = 7 // the endpoint is shutting down
+	RejectAcceptTimeout   RejectCode = 8 // the channel was not accepted in time
+
+	RejectApplication RejectCode = 100 // first code available to applications
+)
+
+func (c RejectCode) String() string {
+	switch c {
+	case RejectRefused:
+		return "refused"
+	case RejectUnknownType:
+		return "unknown type"
+	case RejectBacklogFull:
+		return "backlog full"
+	case RejectInvalidType:
+		return "invalid type"
+	case RejectListenerClosed:
+		return "listener closed"
+	case RejectTooManyChannels:
+		return "too many channels"
+	case RejectShuttingDown:
+		return "shutting down"
+	case RejectAcceptTimeout:
+		return "accept timeout"
+	default:
+		return fmt.Sprintf("code %d", int(c))
+	}
+}
+
+func rejectCodeFor(reason error) RejectCode {
+	switch reason {
+	case ErrListenerUnknownType:
+		return RejectUnknownType
+	case ErrListenerBacklogTooLarge:
+		return RejectBacklogFull
+	case ErrListenerInvalidType:
+		return RejectInvalidType
+	case ErrListenerClosed:
+		return RejectListenerClosed
+	case ErrTooManyChannels:
+		return RejectTooManyChannels
+	case ErrShuttingDown:
+		return RejectShuttingDown
+	case ErrListenerAcceptTimeout:
+		return RejectAcceptTimeout
+	default:
+		return RejectRefused
+	}
+}
+
+// RejectedChannelError is returned by channel operations after the peer
+// rejected the opening of the channel.
+type RejectedChannelError struct {
+	Code   RejectCode
+	Reason string
+}
+
+func (err *RejectedChannelError) Error() string {
+	return fmt.Sprintf("e3x: channel rejected (code=%d reason=%q)", int(err.Code), err.Reason)
+}
+
+// RemoteChannelError is returned by channel operations after the peer
+// terminated the channel with an error (see Channel.Error).
+type RemoteChannelError struct {
+	Reason string
+}
+
+func (err *RemoteChannelError) Error() string {
+	return fmt.Sprintf("e3x: remote channel error: %s", err.Reason)
+}
+
+// OpenHeaders adds h to the headers of the first packet of a locally opened
+// channel. The accepting side finds them on the first packet it reads.
+// Headers set on the packet itself take precedence.
+func OpenHeaders(h map[string]interface{}) ChannelOption {
+	return func(c *Channel) error {
+		if c.openHeaders == nil {
+			c.openHeaders = make(map[string]interface{}, len(h))
+		}
+		for k, v := range h {
+			c.openHeaders[k] = v
+		}
+		return nil
+	}
+}
+
+func (c *Channel) applyOpenHeaders(pkt *lob.Packet) {
+	hdr := pkt.Header()
+	for k, v := range c.openHeaders {
+		if _, found := hdr.Get(k); !found {
+			hdr.Set(k, v)
+		}
+	}
+}
+
+// Reject refuses an accepted channel before anything was written to it. The
+// opener receives a *RejectedChannelError with code and reason.
+func (c *Channel) Reject(code RejectCode, reason string) error {
+	if c == nil {
+		return os.ErrInvalid
+	}
+
+	c.mtx.Lock()
+
+	if c.broken {
+		c.mtx.Unlock()
+		return c.brokenError()
+	}
+
+	if !c.serverside || c.oSeq != cBlankSeq {
+		c.mtx.Unlock()
+		return ErrCannotReject
+	}
+
+	pkt := &lob.Packet{}
+	hdr := pkt.Header()
+	hdr.SetString("err", reason)
+	hdr.SetInt("code", int(code))
+	err := c.write(pkt, nil)
+
+	c.broken = true
+	c.unsetTimers()
+	c.cndWrite.Broadcast()
+	c.cndRead.Broadcast()
+	c.cndClose.Broadcast()
+
+	c.mtx.Unlock()
+
+	c.channelHooks.Closed()
+	return err
+}
+
+// receivedError handles a packet with an "err" header. The channel is broken
+// immediately. The channel must be locked and will be unlocked.
+func (c *Channel) receivedError(pkt *lob.Packet, reason string) {
+	if code, ok := pkt.Header().GetInt("code"); ok {
+		c.remoteErr = &RejectedChannelError{RejectCode(code), reason}
+	} else {
+		c.remoteErr = &RemoteChannelError{reason}
+	}
+
+	c.broken = true
+	c.unsetTimers()
+	c.cndWrite.Broadcast()
+	c.cndRead.Broadcast()
+	c.cndClose.Broadcast()
+
+	c.mtx.Unlock()
+
+	pkt.Free()
+	c.channelHooks.Closed()
+}
+
+// brokenError returns the error returned by operations on a broken channel.
+func (c *Channel) brokenError() error {
+	if c.remoteErr != nil {
+		return c.remoteErr
+	}
+	return &BrokenChannelError{c.hashname, c.typ, c.id}
+}
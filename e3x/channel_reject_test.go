@@ -0,0 +1,145 @@
+package e3x
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestRejectUnknownType(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		assert := assert.New(t)
+
+		var hooks int32
+		A.DefaultChannelHooks().Register(ChannelHook{
+			OnOpened: func(*Endpoint, *Exchange, *Channel) error {
+				atomic.AddInt32(&hooks, 1)
+				return nil
+			},
+			OnClosed: func(*Endpoint, *Exchange, *Channel) error {
+				atomic.AddInt32(&hooks, 1)
+				return nil
+			},
+		})
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "unknown", true)
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		assert.NoError(c.WritePacket(lob.New(nil)))
+
+		_, err = c.ReadPacket()
+		if assert.IsType(&RejectedChannelError{}, err) {
+			assert.Equal(RejectUnknownType, err.(*RejectedChannelError).Code)
+		}
+
+		err = c.WritePacket(lob.New(nil))
+		assert.IsType(&RejectedChannelError{}, err)
+
+		// the refused channel never reached the channel hooks of A
+		assert.Equal(int32(0), atomic.LoadInt32(&hooks))
+	})
+}
+
+func TestRejectWithOpenHeaders(t *testing.T) {
+	const codeBadToken = RejectApplication + 1
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("auth", true).AcceptChannel()
+			if !assert.NoError(err) || !assert.NotNil(c) {
+				return
+			}
+
+			c.SetDeadline(time.Now().Add(10 * time.Second))
+
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+
+			token, _ := pkt.Header().GetString("token")
+			assert.Equal("bad", token)
+			assert.Equal("hello", string(pkt.Body(nil)))
+			pkt.Free()
+
+			assert.NoError(c.Reject(codeBadToken, "bad token"))
+			assert.IsType(&BrokenChannelError{}, c.Reject(codeBadToken, "bad token"))
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "auth", true, OpenHeaders(map[string]interface{}{"token": "bad"}))
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		assert.Equal(ErrCannotReject, c.Reject(codeBadToken, "client"))
+		assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+		_, err = c.ReadPacket()
+		assert.Equal(&RejectedChannelError{codeBadToken, "bad token"}, err)
+		<-done
+	})
+}
+
+func TestRemoteChannelError(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("fail", true).AcceptChannel()
+			if !assert.NoError(err) || !assert.NotNil(c) {
+				return
+			}
+
+			c.SetDeadline(time.Now().Add(10 * time.Second))
+
+			pkt, err := c.ReadPacket()
+			if assert.NoError(err) {
+				pkt.Free()
+			}
+
+			assert.NoError(c.Errorf("failed"))
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "fail", true)
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		assert.NoError(c.WritePacket(lob.New(nil)))
+
+		_, err = c.ReadPacket()
+		assert.Equal(&RemoteChannelError{"failed"}, err)
+		assert.Equal(&RemoteChannelError{"failed"}, c.Close())
+		<-done
+	})
+}
@@ -0,0 +1,94 @@
+package e3x
+
+import (
+	"time"
+)
+
+const (
+	cInitialRTO = 1 * time.Second
+	cMinRTO     = 50 * time.Millisecond
+	cMaxRTO     = 60 * time.Second
+	cMinAckWait = 1 * time.Millisecond
+)
+
+// rttEstimator tracks the smoothed round-trip time of a channel and derives
+// the retransmission timeout from it (RFC 6298).
+type rttEstimator struct {
+	srtt      time.Duration
+	rttvar    time.Duration
+	rto       time.Duration // without backoff
+	backoff   uint
+	hasSample bool
+}
+
+func newRTTEstimator() rttEstimator {
+	return rttEstimator{rto: cInitialRTO}
+}
+
+// Sample adds a new round-trip time measurement. Callers must never sample
+// retransmitted packets (Karn's algorithm).
+func (r *rttEstimator) Sample(d time.Duration) {
+	if d <= 0 {
+		d = time.Microsecond
+	}
+
+	if !r.hasSample {
+		r.hasSample = true
+		r.srtt = d
+		r.rttvar = d / 2
+	} else {
+		delta := r.srtt - d
+		if delta < 0 {
+			delta = -delta
+		}
+		r.rttvar = (3*r.rttvar + delta) / 4
+		r.srtt = (7*r.srtt + d) / 8
+	}
+
+	r.rto = clampRTO(r.srtt + 4*r.rttvar)
+	r.backoff = 0
+}
+
+// Backoff doubles the retransmission timeout until the next sample.
+func (r *rttEstimator) Backoff() {
+	if r.RTO() < cMaxRTO {
+		r.backoff++
+	}
+}
+
+// RTO returns the current retransmission timeout (including backoff).
+func (r *rttEstimator) RTO() time.Duration {
+	return clampRTO(r.rto << r.backoff)
+}
+
+// BaseRTO returns the retransmission timeout without backoff. This is the time
+// after which a retransmitted packet may be retransmitted again.
+func (r *rttEstimator) BaseRTO() time.Duration {
+	return r.rto
+}
+
+// AckDelay returns how long a receiver may wait before acking a packet.
+func (r *rttEstimator) AckDelay() time.Duration {
+	if !r.hasSample {
+		return cAckDelay
+	}
+
+	d := r.srtt / 4
+	if d < cMinAckWait {
+		d = cMinAckWait
+	}
+	if d > cAckDelay {
+		d = cAckDelay
+	}
+	return d
+}
+
+func clampRTO(d time.Duration) time.Duration {
+	if d < cMinRTO {
+		d = cMinRTO
+	}
+	if d > cMaxRTO {
+		d = cMaxRTO
+	}
+	return d
+}
@@ -0,0 +1,92 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	assert := assert.New(t)
+
+	r := newRTTEstimator()
+	assert.Equal(cInitialRTO, r.RTO())
+	assert.Equal(cAckDelay, r.AckDelay())
+
+	r.Sample(100 * time.Millisecond)
+	assert.Equal(100*time.Millisecond, r.srtt)
+	assert.Equal(50*time.Millisecond, r.rttvar)
+	assert.Equal(300*time.Millisecond, r.RTO())
+
+	r.Sample(100 * time.Millisecond)
+	assert.Equal(100*time.Millisecond, r.srtt)
+	assert.Equal(37500*time.Microsecond, r.rttvar)
+	assert.Equal(250*time.Millisecond, r.RTO())
+
+	r.Backoff()
+	assert.Equal(500*time.Millisecond, r.RTO())
+
+	for i := 0; i < 20; i++ {
+		r.Backoff()
+	}
+	assert.Equal(cMaxRTO, r.RTO())
+
+	// LAN peers
+	r = newRTTEstimator()
+	r.Sample(100 * time.Microsecond)
+	assert.Equal(cMinRTO, r.RTO())
+	assert.Equal(cMinAckWait, r.AckDelay())
+}
+
+func TestChannelStatsRTT(t *testing.T) {
+	withTwoLossyEndpoints(t, 0, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("ping", true).AcceptChannel()
+			if !assert.NoError(err) {
+				return
+			}
+			defer c.Close()
+
+			for i := 0; i < 10; i++ {
+				pkt, err := c.ReadPacket()
+				if !assert.NoError(err) {
+					return
+				}
+				assert.NoError(c.WritePacket(pkt))
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "ping", true)
+		if !assert.NoError(err) {
+			return
+		}
+
+		for i := 0; i < 10; i++ {
+			assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+			_, err := c.ReadPacket()
+			assert.NoError(err)
+		}
+
+		stats := c.Stats()
+		assert.True(stats.SRTT > 0)
+		assert.True(stats.RTO >= cMinRTO)
+		assert.True(stats.RTO < cInitialRTO)
+		assert.True(stats.CongestionWindow >= cInitialCongestionWindow)
+
+		assert.NoError(c.Close())
+		<-done
+	})
+}
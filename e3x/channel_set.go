@@ -88,6 +88,11 @@ func (p *channelSetAddPromise) Add(c *Channel) {
 	p.set.mtx.Unlock()
 }
 
+// Len returns the number of channels in the (locked) set.
+func (p *channelSetAddPromise) Len() int {
+	return len(p.set.channels)
+}
+
 func (p *channelSetAddPromise) Cancel() {
 	p.set.mtx.Unlock()
 }
@@ -108,6 +113,13 @@ func (set *channelSet) Add(id uint32, c *Channel) (ok bool) {
 	return true
 }
 
+func (set *channelSet) Len() int {
+	set.mtx.RLock()
+	n := len(set.channels)
+	set.mtx.RUnlock()
+	return n
+}
+
 func (set *channelSet) Idle() bool {
 	set.mtx.RLock()
 	idle := true
@@ -0,0 +1,49 @@
+package e3x
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ChannelStats is a snapshot of the transmission state of a channel.
+type ChannelStats struct {
+	PacketsSent     uint64            // packets written (excluding retransmits and ad-hoc acks)
+	PacketsReceived uint64            // packets received (including dropped packets)
+	BytesSent       uint64            // body bytes written
+	BytesReceived   uint64            // body bytes received
+	Retransmits     uint64            // packets sent again (reliable channels only)
+	Drops           map[string]uint64 // dropped packets by reason
+	ReadBuffered    int               // received packets which were not yet read
+	WriteBuffered   int               // written packets which were not yet acknowledged
+
+	SRTT             time.Duration // smoothed round-trip time
+	RTTVar           time.Duration // round-trip time variation
+	RTO              time.Duration // current retransmission timeout
+	CongestionWindow int           // packets allowed in flight (reliable channels only)
+}
+
+// Stats returns a snapshot of the channel statistics.
+func (c *Channel) Stats() ChannelStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	s := ChannelStats{
+		PacketsSent:     atomic.LoadUint64(&c.stats.packetsSent),
+		PacketsReceived: atomic.LoadUint64(&c.stats.packetsReceived),
+		BytesSent:       atomic.LoadUint64(&c.stats.bytesSent),
+		BytesReceived:   atomic.LoadUint64(&c.stats.bytesReceived),
+		Retransmits:     atomic.LoadUint64(&c.stats.retransmits),
+		Drops:           c.stats.snapshotDrops(nil),
+		ReadBuffered:    len(c.readBuffer),
+		WriteBuffered:   len(c.writeBuffer),
+		SRTT:            c.rtt.srtt,
+		RTTVar:          c.rtt.rttvar,
+		RTO:             c.rtt.RTO(),
+	}
+
+	if c.cc != nil {
+		s.CongestionWindow = c.cc.Window()
+	}
+
+	return s
+}
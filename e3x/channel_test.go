@@ -112,6 +112,73 @@ func TestPingPong(t *testing.T) {
 	})
 }
 
+func TestWriteAfterResponse(t *testing.T) {
+	// A client channel may write again once the response to its first
+	// packet was received, even when the response was not yet read.
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("ping", false).AcceptChannel()
+			if !assert.NoError(err) || !assert.NotNil(c) {
+				return
+			}
+			defer c.Close()
+
+			c.SetDeadline(time.Now().Add(10 * time.Second))
+
+			pkt, err := c.ReadPacket()
+			if assert.NoError(err) && assert.NotNil(pkt) {
+				assert.Equal("ping", string(pkt.Body(nil)))
+			}
+
+			assert.NoError(c.WritePacket(lob.New([]byte("pong"))))
+
+			pkt, err = c.ReadPacket()
+			if assert.NoError(err) && assert.NotNil(pkt) {
+				assert.Equal("again", string(pkt.Body(nil)))
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "ping", false)
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+
+		for i := 0; i < 100; i++ {
+			c.mtx.Lock()
+			received := c.iBufferedSeq != cBlankSeq
+			c.mtx.Unlock()
+			if received {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		c.SetWriteDeadline(time.Now().Add(time.Second))
+		assert.NoError(c.WritePacket(lob.New([]byte("again"))))
+
+		pkt, err := c.ReadPacket()
+		if assert.NoError(err) && assert.NotNil(pkt) {
+			assert.Equal("pong", string(pkt.Body(nil)))
+		}
+
+		assert.NoError(c.Close())
+		<-done
+	})
+}
+
 func TestPingPongReliable(t *testing.T) {
 	// t.Parallel()
 	logs.ResetLogger()
@@ -229,6 +296,112 @@ func TestFloodReliable(t *testing.T) {
 	})
 }
 
+func TestCloseWrite(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+		)
+
+		// echo server
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("echo", true).AcceptChannel()
+			if assert.NoError(err) && assert.NotNil(c) {
+				c.SetDeadline(time.Now().Add(10 * time.Second))
+
+				_, err = io.Copy(c, c)
+				assert.NoError(err)
+				assert.NoError(c.CloseWrite())
+				assert.NoError(c.Close())
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "echo", true)
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		var buf bytes.Buffer
+		for _, s := range []string{"a", "b", "c"} {
+			_, err = c.Write([]byte(s))
+			assert.NoError(err)
+
+			// the first write must be answered before writing more
+			if s == "a" {
+				_, err = io.CopyN(&buf, c, 1)
+				assert.NoError(err)
+			}
+		}
+
+		assert.NoError(c.CloseWrite())
+		_, err = c.Write([]byte("d"))
+		assert.Equal(io.EOF, err)
+
+		_, err = io.Copy(&buf, c)
+		assert.NoError(err)
+		assert.Equal("abc", buf.String())
+
+		assert.NoError(c.Close())
+		<-done
+	})
+}
+
+func TestCloseRead(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("sink", true).AcceptChannel()
+			if assert.NoError(err) && assert.NotNil(c) {
+				c.SetDeadline(time.Now().Add(10 * time.Second))
+
+				pkt, err := c.ReadPacket()
+				if assert.NoError(err) {
+					pkt.Free()
+				}
+
+				for i := 0; i < 2*cReadBufferSize; i++ {
+					assert.NoError(c.WritePacket(lob.New([]byte("x"))))
+				}
+
+				assert.NoError(c.Close())
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "sink", true)
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		assert.NoError(c.WritePacket(lob.New(nil)))
+		assert.NoError(c.CloseRead())
+
+		_, err = c.ReadPacket()
+		assert.Equal(io.EOF, err)
+
+		// the peer must be able to write more than a full window
+		assert.NoError(c.Close())
+		<-done
+	})
+}
+
 func BenchmarkReadWriteReliable(b *testing.B) {
 	defer dumpExpVar(b)
 	logs.ResetLogger()
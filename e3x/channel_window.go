@@ -0,0 +1,41 @@
+package e3x
+
+import (
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+const (
+	cMinReceiveWindow = 1
+	cMaxReceiveWindow = 4096
+)
+
+// ReceiveWindow sets the number of unread packets a channel is willing to
+// buffer. The window is advertised to the peer (with the "wnd" header) which
+// will not send packets beyond it. The default window is 100 packets.
+func ReceiveWindow(n int) ChannelOption {
+	return func(c *Channel) error {
+		if n < cMinReceiveWindow {
+			n = cMinReceiveWindow
+		}
+		if n > cMaxReceiveWindow {
+			n = cMaxReceiveWindow
+		}
+		c.iWindow = uint32(n)
+		return nil
+	}
+}
+
+func (c *Channel) applyWindowHeader(pkt *lob.Packet) {
+	pkt.Header().SetUint32("wnd", c.iWindow)
+}
+
+func (c *Channel) processWindowHeader(pkt *lob.Packet) {
+	hdr := pkt.Header()
+	if wnd, ok := hdr.GetUint32("wnd"); ok {
+		if wnd < cMinReceiveWindow {
+			wnd = cMinReceiveWindow
+		}
+		c.oWindow = wnd
+		delete(hdr.Extra, "wnd")
+	}
+}
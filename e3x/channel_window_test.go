@@ -0,0 +1,86 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestReceiveWindow(t *testing.T) {
+	const (
+		window = 4
+		n      = 20
+	)
+
+	withTwoLossyEndpoints(t, 0, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+			srv    = make(chan *Channel, 1)
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("window", true).AcceptChannel()
+			if !assert.NoError(err) || !assert.NotNil(c) {
+				return
+			}
+			defer c.Close()
+			srv <- c
+
+			c.SetDeadline(time.Now().Add(10 * time.Second))
+
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+			pkt.Free()
+
+			for i := 0; i < n; i++ {
+				pkt := lob.New(nil)
+				pkt.Header().SetInt("id", i)
+				if !assert.NoError(c.WritePacket(pkt)) {
+					return
+				}
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "window", true, ReceiveWindow(window))
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		assert.NoError(c.WritePacket(lob.New(nil)))
+
+		// don't read; the server must stop writing when the window is full.
+		s := <-srv
+		time.Sleep(200 * time.Millisecond)
+		s.mtx.Lock()
+		assert.Equal(uint32(window), s.oWindow)
+		assert.Equal(uint32(window), s.oSeq)
+		s.mtx.Unlock()
+
+		for i := 0; i < n; i++ {
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				break
+			}
+			id, _ := pkt.Header().GetInt("id")
+			assert.Equal(i, id)
+			_, found := pkt.Header().Get("wnd")
+			assert.False(found)
+			pkt.Free()
+		}
+
+		assert.NoError(c.Close())
+		<-done
+	})
+}
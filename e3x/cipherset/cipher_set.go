@@ -53,6 +53,15 @@ type State interface {
 	DecryptPacket(pkt *lob.Packet) (*lob.Packet, error)
 }
 
+// StateMarshaler is implemented by states which can export their line keys.
+// A state restored with UnmarshalState can continue a line without a new
+// handshake. The exported state contains private keys and must be protected
+// accordingly.
+type StateMarshaler interface {
+	MarshalState() ([]byte, error)
+	UnmarshalState(p []byte) error
+}
+
 type Handshake interface {
 	CSID() uint8
 
@@ -296,14 +296,6 @@ func (s *state) SetRemoteKey(remoteKey cipherset.Key) error {
 	return cipherset.ErrInvalidKey
 }
 
-func (s *state) setRemoteLineKey(k *key) {
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
-
-	s.remoteLineKey = k
-	s.update()
-}
-
 func (s *state) update() {
 	// generate a local line Key
 	if s.localLineKey == nil {
@@ -461,6 +453,9 @@ func (s *state) ApplyHandshake(h cipherset.Handshake) bool {
 		return false
 	}
 
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
 	if s.remoteKey != nil && !bytes.Equal(s.remoteKey.Public(), hs.key.Public()) {
 		return false
 	}
@@ -472,10 +467,11 @@ func (s *state) ApplyHandshake(h cipherset.Handshake) bool {
 		s.lineEncryptionKey = nil
 	}
 
-	s.setRemoteLineKey(hs.lineKey)
-	if s.remoteKey == nil {
-		s.SetRemoteKey(hs.key)
+	s.remoteLineKey = hs.lineKey
+	if s.remoteKey == nil && hs.key != nil && hs.key.CanEncrypt() {
+		s.remoteKey = hs.key
 	}
+	s.update()
 	return true
 }
 
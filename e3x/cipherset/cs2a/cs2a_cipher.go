@@ -0,0 +1,660 @@
+package cs2a
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	Cipher "crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+var (
+	_ cipherset.Cipher         = (*cipher)(nil)
+	_ cipherset.State          = (*state)(nil)
+	_ cipherset.StateMarshaler = (*state)(nil)
+	_ cipherset.Key            = (*key)(nil)
+	_ cipherset.Handshake      = (*handshake)(nil)
+)
+
+const (
+	lenRSA     = bitsRSA / 8
+	lenLineKey = 65
+	lenLinePrv = 32
+	lenIV      = 12
+	lenAuth    = 16
+	lenToken   = 16
+
+	// overhead of a message: KEY + IV + the INNER auth tag + SIG
+	lenMessageOverhead = lenRSA + lenIV + lenAuth + lenRSA + lenAuth
+)
+
+func init() {
+	cipherset.Register(0x2a, &cipher{})
+}
+
+type cipher struct{}
+
+type handshake struct {
+	key     *key
+	lineKey *ecdh.PublicKey
+	token   cipherset.Token
+	parts   cipherset.Parts
+	at      uint32
+}
+
+func (h *handshake) Parts() cipherset.Parts {
+	return h.parts
+}
+
+func (h *handshake) PublicKey() cipherset.Key {
+	return h.key
+}
+
+func (h *handshake) At() uint32 { return h.at }
+func (*handshake) CSID() uint8  { return 0x2a }
+func (*cipher) CSID() uint8     { return 0x2a }
+
+func (c *cipher) DecodeKeyBytes(pub, prv []byte) (cipherset.Key, error) {
+	return decodeKeyBytes(pub, prv)
+}
+
+func (c *cipher) GenerateKey() (cipherset.Key, error) {
+	return generateKey()
+}
+
+func (c *cipher) NewState(localKey cipherset.Key) (cipherset.State, error) {
+	if k, ok := localKey.(*key); ok && k != nil && k.CanEncrypt() && k.CanSign() {
+		s := &state{localKey: k}
+		if err := s.update(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, cipherset.ErrInvalidKey
+}
+
+func (c *cipher) DecryptMessage(localKey, remoteKey cipherset.Key, p []byte) ([]byte, error) {
+	var (
+		cs2aLocalKey, _  = localKey.(*key)
+		cs2aRemoteKey, _ = remoteKey.(*key)
+	)
+
+	if !cs2aLocalKey.CanSign() || !cs2aRemoteKey.CanEncrypt() {
+		return nil, cipherset.ErrInvalidState
+	}
+
+	inner, linePub, err := openMessage(cs2aLocalKey, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !verifyMessage(cs2aRemoteKey, linePub, p) {
+		return nil, cipherset.ErrInvalidMessage
+	}
+
+	return inner, nil
+}
+
+func (c *cipher) DecryptHandshake(localKey cipherset.Key, p []byte) (cipherset.Handshake, error) {
+	var (
+		cs2aLocalKey, _ = localKey.(*key)
+		hshake          *handshake
+	)
+
+	if !cs2aLocalKey.CanSign() {
+		return nil, cipherset.ErrInvalidState
+	}
+
+	data, linePub, err := openMessage(cs2aLocalKey, p)
+	if err != nil {
+		return nil, err
+	}
+
+	lineKey, err := ecdh.P256().NewPublicKey(linePub)
+	if err != nil {
+		return nil, cipherset.ErrInvalidMessage
+	}
+
+	{ // decode inner
+		inner, err := lob.Decode(bufpool.New().Set(data))
+		if err != nil {
+			return nil, cipherset.ErrInvalidMessage
+		}
+
+		at, hasAt := inner.Header().GetUint32("at")
+		if !hasAt {
+			return nil, cipherset.ErrInvalidMessage
+		}
+
+		delete(inner.Header().Extra, "at")
+
+		parts, err := cipherset.PartsFromHeader(inner.Header())
+		if err != nil {
+			return nil, cipherset.ErrInvalidMessage
+		}
+
+		remoteKey, err := decodeKeyBytes(inner.Body(nil), nil)
+		if err != nil || !remoteKey.CanEncrypt() {
+			return nil, cipherset.ErrInvalidMessage
+		}
+
+		hshake = &handshake{}
+		hshake.at = at
+		hshake.key = remoteKey
+		hshake.lineKey = lineKey
+		hshake.parts = parts
+		hshake.token = makeToken(p)
+	}
+
+	if !verifyMessage(hshake.key, linePub, p) {
+		return nil, cipherset.ErrInvalidMessage
+	}
+
+	return hshake, nil
+}
+
+// openMessage decrypts the line key and the inner message of p.
+func openMessage(localKey *key, p []byte) (inner, linePub []byte, err error) {
+	if len(p) < lenMessageOverhead {
+		return nil, nil, cipherset.ErrInvalidMessage
+	}
+
+	var (
+		ctLen = len(p) - (lenRSA + lenIV + lenRSA + lenAuth)
+		box   = p[:lenRSA]
+		iv    = p[lenRSA : lenRSA+lenIV]
+		ct    = p[lenRSA+lenIV : lenRSA+lenIV+ctLen]
+	)
+
+	linePub, err = rsa.DecryptOAEP(sha1.New(), nil, localKey.prv, box, nil)
+	if err != nil || len(linePub) != lenLineKey {
+		return nil, nil, cipherset.ErrInvalidMessage
+	}
+
+	innerKey := sha256.Sum256(linePub)
+	aead, err := newAEAD(innerKey[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inner, err = aead.Open(nil, iv, ct, nil)
+	if err != nil {
+		return nil, nil, cipherset.ErrInvalidMessage
+	}
+
+	return inner, linePub, nil
+}
+
+// verifyMessage decrypts and checks the signature of p. The message must have
+// been opened by openMessage.
+func verifyMessage(remoteKey *key, linePub, p []byte) bool {
+	var (
+		ctLen  = len(p) - (lenRSA + lenIV + lenRSA + lenAuth)
+		signed = p[:lenRSA+lenIV+ctLen]
+		iv     = p[lenRSA : lenRSA+lenIV]
+		sigBox = p[lenRSA+lenIV+ctLen:]
+	)
+
+	sigKey := signatureKey(linePub, iv)
+	aead, err := newAEAD(sigKey[:])
+	if err != nil {
+		return false
+	}
+
+	sig, err := aead.Open(nil, iv, sigBox, nil)
+	if err != nil {
+		return false
+	}
+
+	digest := sha256.Sum256(signed)
+	return rsa.VerifyPKCS1v15(remoteKey.pub, crypto.SHA256, digest[:], sig) == nil
+}
+
+func signatureKey(linePub, iv []byte) [32]byte {
+	sha := sha256.New()
+	sha.Write(linePub)
+	sha.Write(iv)
+
+	var k [32]byte
+	sha.Sum(k[:0])
+	return k
+}
+
+func newAEAD(k []byte) (Cipher.AEAD, error) {
+	aesBlock, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return Cipher.NewGCM(aesBlock)
+}
+
+// makeToken returns the token of the line of message p (see
+// cipherset.ExtractToken).
+func makeToken(p []byte) cipherset.Token {
+	var token cipherset.Token
+	sha := sha256.Sum256(p[:lenToken])
+	copy(token[:], sha[:lenToken])
+	return token
+}
+
+type state struct {
+	mtx               sync.RWMutex
+	localKey          *key
+	remoteKey         *key
+	localLineKey      *ecdh.PrivateKey
+	remoteLineKey     *ecdh.PublicKey
+	localLineBox      []byte // KEY: the local line key encrypted to the remote key
+	localToken        *cipherset.Token
+	remoteToken       *cipherset.Token
+	lineEncryptionKey Cipher.AEAD
+	lineDecryptionKey Cipher.AEAD
+	pktIVPrefix       *[4]byte
+	pktIVSuffix       uint64
+}
+
+func (*state) CSID() uint8 { return 0x2a }
+
+func (s *state) IsHigh() bool {
+	if s.localKey != nil && s.remoteKey != nil {
+		return bytes.Compare(s.remoteKey.pubDER, s.localKey.pubDER) < 0
+	}
+	return false
+}
+
+func (s *state) LocalToken() cipherset.Token {
+	if s.localToken != nil {
+		return *s.localToken
+	}
+	return cipherset.ZeroToken
+}
+
+func (s *state) RemoteToken() cipherset.Token {
+	if s.remoteToken != nil {
+		return *s.remoteToken
+	}
+	return cipherset.ZeroToken
+}
+
+func (s *state) SetRemoteKey(remoteKey cipherset.Key) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if k, ok := remoteKey.(*key); ok && k != nil && k.CanEncrypt() {
+		s.remoteKey = k
+		return s.update()
+	}
+
+	return cipherset.ErrInvalidKey
+}
+
+func (s *state) update() error {
+	if s.pktIVPrefix == nil {
+		var prefix [4]byte
+		if _, err := io.ReadFull(rand.Reader, prefix[:]); err != nil {
+			return err
+		}
+		s.pktIVPrefix = &prefix
+	}
+
+	// generate a local line Key
+	if s.localLineKey == nil {
+		k, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		s.localLineKey = k
+	}
+
+	// encrypt the local line key
+	if s.localLineBox == nil && s.remoteKey.CanEncrypt() {
+		box, err := rsa.EncryptOAEP(sha1.New(), rand.Reader,
+			s.remoteKey.pub, s.localLineKey.PublicKey().Bytes(), nil)
+		if err != nil {
+			return err
+		}
+		s.localLineBox = box
+	}
+
+	// make local token
+	if s.localToken == nil && s.localLineBox != nil {
+		s.localToken = new(cipherset.Token)
+		*s.localToken = makeToken(s.localLineBox)
+	}
+
+	// generate line keys
+	if s.localToken != nil && s.remoteToken != nil && s.remoteLineKey != nil &&
+		(s.lineEncryptionKey == nil || s.lineDecryptionKey == nil) {
+		sharedKey, err := s.localLineKey.ECDH(s.remoteLineKey)
+		if err != nil {
+			return err
+		}
+
+		var (
+			localPub  = s.localLineKey.PublicKey().Bytes()
+			remotePub = s.remoteLineKey.Bytes()
+		)
+
+		sha := sha256.New()
+		sha.Write(sharedKey)
+		sha.Write(localPub)
+		sha.Write(remotePub)
+		encryptionKey, err := newAEAD(sha.Sum(nil))
+		if err != nil {
+			return err
+		}
+
+		sha.Reset()
+		sha.Write(sharedKey)
+		sha.Write(remotePub)
+		sha.Write(localPub)
+		decryptionKey, err := newAEAD(sha.Sum(nil))
+		if err != nil {
+			return err
+		}
+
+		s.lineEncryptionKey = encryptionKey
+		s.lineDecryptionKey = decryptionKey
+	}
+
+	return nil
+}
+
+func (s *state) NeedsRemoteKey() bool {
+	return s.remoteKey == nil
+}
+
+func (s *state) CanEncryptMessage() bool {
+	return s.localKey != nil && s.remoteKey != nil && s.localLineBox != nil
+}
+
+func (s *state) CanEncryptHandshake() bool {
+	return s.CanEncryptMessage()
+}
+
+func (s *state) CanEncryptPacket() bool {
+	return s.lineEncryptionKey != nil && s.remoteToken != nil
+}
+
+func (s *state) CanDecryptMessage() bool {
+	return s.localKey != nil && s.remoteKey != nil && s.localLineKey != nil
+}
+
+func (s *state) CanDecryptHandshake() bool {
+	return s.localKey != nil && s.localLineKey != nil
+}
+
+func (s *state) CanDecryptPacket() bool {
+	return s.lineDecryptionKey != nil && s.localToken != nil
+}
+
+func (s *state) EncryptMessage(in []byte) ([]byte, error) {
+	if !s.CanEncryptMessage() {
+		panic("unable to encrypt message")
+	}
+
+	var (
+		ctLen   = len(in) + lenAuth
+		out     = make([]byte, lenRSA+lenIV, len(in)+lenMessageOverhead)
+		iv      = out[lenRSA : lenRSA+lenIV]
+		linePub = s.localLineKey.PublicKey().Bytes()
+	)
+
+	// copy the encrypted line key
+	copy(out[:lenRSA], s.localLineBox)
+
+	// make the iv
+	_, err := io.ReadFull(rand.Reader, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	{ // encrypt inner
+		innerKey := sha256.Sum256(linePub)
+		aead, err := newAEAD(innerKey[:])
+		if err != nil {
+			return nil, err
+		}
+
+		out = aead.Seal(out, iv, in, nil)
+	}
+
+	{ // sign and encrypt the signature
+		digest := sha256.Sum256(out[:lenRSA+lenIV+ctLen])
+		sig, err := rsa.SignPKCS1v15(rand.Reader, s.localKey.prv, crypto.SHA256, digest[:])
+		if err != nil {
+			return nil, err
+		}
+
+		sigKey := signatureKey(linePub, iv)
+		aead, err := newAEAD(sigKey[:])
+		if err != nil {
+			return nil, err
+		}
+
+		out = aead.Seal(out, iv, sig, nil)
+	}
+
+	return out, nil
+}
+
+func (s *state) EncryptHandshake(at uint32, compact cipherset.Parts) ([]byte, error) {
+	pkt := lob.New(s.localKey.Public())
+	compact.ApplyToHeader(pkt.Header())
+	pkt.Header().SetUint32("at", at)
+	data, err := lob.Encode(pkt)
+	if err != nil {
+		return nil, err
+	}
+	return s.EncryptMessage(data.Get(nil))
+}
+
+func (s *state) ApplyHandshake(h cipherset.Handshake) bool {
+	var (
+		hs, _ = h.(*handshake)
+	)
+
+	if hs == nil {
+		return false
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.remoteKey != nil && !bytes.Equal(s.remoteKey.pubDER, hs.key.pubDER) {
+		return false
+	}
+
+	if s.remoteLineKey != nil && !s.remoteLineKey.Equal(hs.lineKey) {
+		s.remoteLineKey = nil
+		s.remoteToken = nil
+		s.lineDecryptionKey = nil
+		s.lineEncryptionKey = nil
+	}
+
+	s.remoteLineKey = hs.lineKey
+	s.remoteToken = &hs.token
+	if s.remoteKey == nil && hs.key != nil && hs.key.CanEncrypt() {
+		s.remoteKey = hs.key
+	}
+	return s.update() == nil
+}
+
+// MarshalState returns the local line key, the remote line key, the encrypted
+// local line key and the remote token.
+func (s *state) MarshalState() ([]byte, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if s.localLineKey == nil || s.remoteLineKey == nil || s.localLineBox == nil || s.remoteToken == nil {
+		return nil, cipherset.ErrInvalidState
+	}
+
+	p := make([]byte, 0, lenLinePrv+lenLineKey+lenRSA+lenToken)
+	p = append(p, s.localLineKey.Bytes()...)
+	p = append(p, s.remoteLineKey.Bytes()...)
+	p = append(p, s.localLineBox...)
+	p = append(p, (*s.remoteToken)[:]...)
+	return p, nil
+}
+
+// UnmarshalState restores the line keys returned by MarshalState. The packet
+// IVs are regenerated so they are never reused.
+func (s *state) UnmarshalState(p []byte) error {
+	if len(p) != lenLinePrv+lenLineKey+lenRSA+lenToken {
+		return cipherset.ErrInvalidState
+	}
+
+	localLineKey, err := ecdh.P256().NewPrivateKey(p[:lenLinePrv])
+	if err != nil {
+		return cipherset.ErrInvalidState
+	}
+	p = p[lenLinePrv:]
+
+	remoteLineKey, err := ecdh.P256().NewPublicKey(p[:lenLineKey])
+	if err != nil {
+		return cipherset.ErrInvalidState
+	}
+	p = p[lenLineKey:]
+
+	var remoteToken cipherset.Token
+	copy(remoteToken[:], p[lenRSA:])
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.localLineKey = localLineKey
+	s.remoteLineKey = remoteLineKey
+	s.localLineBox = append([]byte(nil), p[:lenRSA]...)
+	s.localToken = nil
+	s.remoteToken = &remoteToken
+	s.lineEncryptionKey = nil
+	s.lineDecryptionKey = nil
+	s.pktIVPrefix = nil
+	s.pktIVSuffix = 0
+	return s.update()
+}
+
+func (s *state) EncryptPacket(pkt *lob.Packet) (*lob.Packet, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var (
+		outer   *lob.Packet
+		inner   *bufpool.Buffer
+		body    *bufpool.Buffer
+		bodyRaw []byte
+		iv      [lenIV]byte
+		ctLen   int
+		err     error
+	)
+
+	if !s.CanEncryptPacket() {
+		return nil, cipherset.ErrInvalidState
+	}
+	if pkt == nil {
+		return nil, nil
+	}
+
+	// encode inner packet
+	inner, err = lob.Encode(pkt)
+	if err != nil {
+		return nil, err
+	}
+
+	// make iv
+	copy(iv[:], s.pktIVPrefix[:])
+	ivSuffix := atomic.AddUint64(&s.pktIVSuffix, 1)
+	binary.BigEndian.PutUint64(iv[4:], ivSuffix)
+
+	// alloc enough space
+	body = bufpool.New().SetLen(lenToken + lenIV + inner.Len() + lenAuth)
+	bodyRaw = body.RawBytes()
+
+	// copy token
+	copy(bodyRaw[:lenToken], s.remoteToken[:])
+
+	// copy iv
+	copy(bodyRaw[lenToken:lenToken+lenIV], iv[:])
+
+	// encrypt inner packet
+	ctLen = len(s.lineEncryptionKey.Seal(
+		bodyRaw[lenToken+lenIV:lenToken+lenIV], iv[:], inner.RawBytes(), bodyRaw[:lenToken]))
+	body.SetLen(lenToken + lenIV + ctLen)
+
+	outer = lob.New(body.RawBytes())
+	inner.Free()
+	body.Free()
+
+	return outer, nil
+}
+
+func (s *state) DecryptPacket(pkt *lob.Packet) (*lob.Packet, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if !s.CanDecryptPacket() {
+		return nil, cipherset.ErrInvalidState
+	}
+	if pkt == nil {
+		return nil, nil
+	}
+
+	if !pkt.Header().IsZero() || pkt.BodyLen() < lenToken+lenIV+lenAuth {
+		return nil, cipherset.ErrInvalidPacket
+	}
+
+	var (
+		bodyRaw  []byte
+		innerRaw []byte
+		innerPkt *lob.Packet
+		body     = bufpool.New()
+		inner    = bufpool.New()
+		err      error
+	)
+
+	pkt.Body(body.SetLen(pkt.BodyLen()).RawBytes()[:0])
+	bodyRaw = body.RawBytes()
+	innerRaw = inner.RawBytes()
+
+	// compare token
+	if !bytes.Equal(bodyRaw[:lenToken], (*s.localToken)[:]) {
+		inner.Free()
+		body.Free()
+		return nil, cipherset.ErrInvalidPacket
+	}
+
+	// decrypt inner packet
+	innerRaw, err = s.lineDecryptionKey.Open(
+		innerRaw[:0], bodyRaw[lenToken:lenToken+lenIV], bodyRaw[lenToken+lenIV:], bodyRaw[:lenToken])
+	if err != nil {
+		inner.Free()
+		body.Free()
+		return nil, cipherset.ErrInvalidPacket
+	}
+	inner.SetLen(len(innerRaw))
+
+	innerPkt, err = lob.Decode(inner)
+	if err != nil {
+		inner.Free()
+		body.Free()
+		return nil, err
+	}
+
+	inner.Free()
+	body.Free()
+
+	return innerPkt, nil
+}
@@ -0,0 +1,187 @@
+package cs2a
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/e3x/cipherset/tests"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+func TestCipher(t *testing.T) {
+	tests.Run(t, &cipher{})
+}
+
+func BenchmarkPacketEncryption(b *testing.B) {
+	tests.BenchmarkPacketEncryption(b, &cipher{})
+}
+
+func BenchmarkPacketDecryption(b *testing.B) {
+	tests.BenchmarkPacketDecryption(b, &cipher{})
+}
+
+// hexBytes is a hex encoded JSON string.
+type hexBytes []byte
+
+func (b *hexBytes) UnmarshalJSON(p []byte) error {
+	var s string
+	if err := json.Unmarshal(p, &s); err != nil {
+		return err
+	}
+	d, err := hex.DecodeString(s)
+	*b = d
+	return err
+}
+
+// vectors are the regression vectors in testdata/vectors.json. Every message,
+// handshake and packet is sent from A to B. They were produced by this package
+// and pin its wire format against accidental changes; they don't show that it
+// interoperates with other CS2a implementations. The packet vector depends on
+// the MarshalState format of this package.
+type vectors struct {
+	A, B struct {
+		Public  hexBytes `json:"public"`
+		Private hexBytes `json:"private"`
+	}
+	Message struct {
+		Inner hexBytes `json:"inner"`
+		Box   hexBytes `json:"box"`
+	}
+	Handshake struct {
+		At  uint32   `json:"at"`
+		Box hexBytes `json:"box"`
+	}
+	Packet struct {
+		State hexBytes `json:"state"` // MarshalState of B
+		Inner hexBytes `json:"inner"`
+		Box   hexBytes `json:"box"`
+	}
+}
+
+func TestRegressionVectors(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := ioutil.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v vectors
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &cipher{}
+
+	ka, err := c.DecodeKeyBytes(v.A.Public, v.A.Private)
+	if !assert.NoError(err) {
+		return
+	}
+	kb, err := c.DecodeKeyBytes(v.B.Public, v.B.Private)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]byte(v.A.Public), ka.Public())
+	assert.Equal([]byte(v.B.Private), kb.Private())
+
+	msg, err := c.DecryptMessage(kb, ka, v.Message.Box)
+	if assert.NoError(err) {
+		assert.Equal([]byte(v.Message.Inner), msg)
+	}
+
+	h, err := c.DecryptHandshake(kb, v.Handshake.Box)
+	if assert.NoError(err) {
+		assert.Equal(v.Handshake.At, h.At())
+		assert.Equal(ka.Public(), h.PublicKey().Public())
+	}
+
+	s, err := c.NewState(kb)
+	assert.NoError(err)
+	assert.NoError(s.SetRemoteKey(ka))
+	assert.NoError(s.(*state).UnmarshalState(v.Packet.State))
+
+	outer, err := lob.Decode(bufpool.New().Set(v.Packet.Box))
+	if !assert.NoError(err) {
+		return
+	}
+	inner, err := s.DecryptPacket(outer)
+	if assert.NoError(err) {
+		raw, err := lob.Encode(inner)
+		if assert.NoError(err) {
+			assert.Equal([]byte(v.Packet.Inner), raw.Get(nil))
+		}
+	}
+}
+
+func TestKeyCoding(t *testing.T) {
+	assert := assert.New(t)
+
+	ka, err := generateKey()
+	assert.NoError(err)
+	kb, err := generateKey()
+	assert.NoError(err)
+
+	k, err := decodeKeyBytes(ka.Public(), ka.Private())
+	if assert.NoError(err) {
+		assert.True(k.CanSign())
+		assert.Equal(ka.Public(), k.Public())
+		assert.Equal(ka.Private(), k.Private())
+	}
+
+	k, err = decodeKeyBytes(ka.Public(), nil)
+	if assert.NoError(err) {
+		assert.False(k.CanSign())
+		assert.True(k.CanEncrypt())
+	}
+
+	_, err = decodeKeyBytes(ka.Public(), kb.Private())
+	assert.Equal(cipherset.ErrInvalidKey, err)
+
+	prv, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(err)
+	_, err = decodeKeyBytes(nil, x509.MarshalPKCS1PrivateKey(prv))
+	assert.Equal(cipherset.ErrInvalidKey, err)
+}
+
+func TestTamperedMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	ka, err := generateKey()
+	assert.NoError(err)
+	kb, err := generateKey()
+	assert.NoError(err)
+
+	s, err := (&cipher{}).NewState(ka)
+	assert.NoError(err)
+	assert.NoError(s.SetRemoteKey(kb))
+
+	box, err := s.EncryptMessage([]byte("Hello World!"))
+	assert.NoError(err)
+
+	// KEY, IV, INNER and SIG are authenticated
+	for _, i := range []int{0, lenRSA, lenRSA + lenIV, len(box) - 1} {
+		tampered := append([]byte(nil), box...)
+		tampered[i] ^= 1
+		_, err = (&cipher{}).DecryptMessage(kb, ka, tampered)
+		assert.Equal(cipherset.ErrInvalidMessage, err, "offset %d", i)
+	}
+
+	// the message must be signed by the remote key
+	_, err = (&cipher{}).DecryptMessage(kb, kb, box)
+	assert.Equal(cipherset.ErrInvalidMessage, err)
+
+	// every message of a line has the same token
+	box2, err := s.EncryptMessage([]byte("Hello again!"))
+	assert.NoError(err)
+	assert.Equal(cipherset.ExtractToken(append([]byte{0, 1, 0x2a}, box...)), s.LocalToken())
+	assert.Equal(makeToken(box), makeToken(box2))
+}
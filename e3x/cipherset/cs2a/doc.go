@@ -0,0 +1,34 @@
+// Package cs2a implements Cipher Set 2a (RSA-2048, ECC P-256 and
+// AES-256-GCM).
+//
+// Keys are 2048 bit RSA keys. Public keys are encoded as DER
+// SubjectPublicKeyInfo, private keys as DER PKCS #1.
+//
+// A message is KEY + IV + INNER + SIG:
+//
+//	KEY    256 bytes  the sender's line key (an uncompressed P-256 point)
+//	                  encrypted with RSA-OAEP (SHA-1) to the recipient
+//	IV     12 bytes   random
+//	INNER  n+16       the message, AES-256-GCM with SHA256(line key)
+//	SIG    256+16     the sender's PKCS #1 v1.5 SHA-256 signature of
+//	                  KEY + IV + INNER, AES-256-GCM with
+//	                  SHA256(line key + IV)
+//
+// The KEY of a line is encrypted once so the token of the line
+// (SHA256(KEY[:16])[:16]) stays the same for every handshake.
+//
+// A packet is TOKEN + IV + CIPHERTEXT where CIPHERTEXT is the inner packet
+// encrypted with AES-256-GCM using the TOKEN as additional data. The line
+// keys are SHA256(ECDH + sender line key + receiver line key).
+//
+// # Compatibility
+//
+// This package has not been tested against other CS2a implementations. The
+// test vectors in testdata were produced by this package and only guard
+// against regressions.
+//
+// # Reference
+//
+// Cipher Sets: https://github.com/telehash/telehash.org/blob/v3/v3/e3x/cs/README.md
+// CS2a: https://github.com/telehash/telehash.org/blob/v3/v3/e3x/cs/2a.md
+package cs2a
@@ -0,0 +1,114 @@
+package cs2a
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/util/base32util"
+)
+
+const bitsRSA = 2048
+
+type key struct {
+	pub    *rsa.PublicKey
+	prv    *rsa.PrivateKey
+	pubDER []byte
+}
+
+func makeKey(pub *rsa.PublicKey, prv *rsa.PrivateKey) (*key, error) {
+	if pub == nil && prv != nil {
+		pub = &prv.PublicKey
+	}
+
+	k := &key{pub: pub, prv: prv}
+
+	if pub != nil {
+		if pub.N.BitLen() != bitsRSA {
+			return nil, cipherset.ErrInvalidKey
+		}
+
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return nil, cipherset.ErrInvalidKey
+		}
+		k.pubDER = der
+	}
+
+	return k, nil
+}
+
+func decodeKeyBytes(pub, prv []byte) (*key, error) {
+	var (
+		pubKey *rsa.PublicKey
+		prvKey *rsa.PrivateKey
+	)
+
+	if len(pub) != 0 {
+		k, err := x509.ParsePKIXPublicKey(pub)
+		if err != nil {
+			return nil, cipherset.ErrInvalidKey
+		}
+
+		pubKey, _ = k.(*rsa.PublicKey)
+		if pubKey == nil {
+			return nil, cipherset.ErrInvalidKey
+		}
+	}
+
+	if len(prv) != 0 {
+		k, err := x509.ParsePKCS1PrivateKey(prv)
+		if err != nil {
+			return nil, cipherset.ErrInvalidKey
+		}
+
+		if pubKey != nil && (pubKey.N.Cmp(k.N) != 0 || pubKey.E != k.E) {
+			return nil, cipherset.ErrInvalidKey
+		}
+		prvKey = k
+	}
+
+	return makeKey(pubKey, prvKey)
+}
+
+func generateKey() (*key, error) {
+	prv, err := rsa.GenerateKey(rand.Reader, bitsRSA)
+	if err != nil {
+		return nil, err
+	}
+
+	return makeKey(nil, prv)
+}
+
+func (k *key) CSID() uint8 { return 0x2a }
+
+func (k *key) Public() []byte {
+	if k == nil || k.pubDER == nil {
+		return nil
+	}
+
+	buf := make([]byte, len(k.pubDER))
+	copy(buf, k.pubDER)
+	return buf
+}
+
+func (k *key) Private() []byte {
+	if k == nil || k.prv == nil {
+		return nil
+	}
+
+	return x509.MarshalPKCS1PrivateKey(k.prv)
+}
+
+func (k *key) String() string {
+	return base32util.EncodeToString(k.Public())
+}
+
+func (k *key) CanSign() bool {
+	return k != nil && k.prv != nil
+}
+
+func (k *key) CanEncrypt() bool {
+	return k != nil && k.pub != nil
+}
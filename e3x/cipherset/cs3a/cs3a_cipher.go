@@ -19,10 +19,11 @@ import (
 )
 
 var (
-	_ cipherset.Cipher    = (*cipher)(nil)
-	_ cipherset.State     = (*state)(nil)
-	_ cipherset.Key       = (*key)(nil)
-	_ cipherset.Handshake = (*handshake)(nil)
+	_ cipherset.Cipher         = (*cipher)(nil)
+	_ cipherset.State          = (*state)(nil)
+	_ cipherset.StateMarshaler = (*state)(nil)
+	_ cipherset.Key            = (*key)(nil)
+	_ cipherset.Handshake      = (*handshake)(nil)
 )
 
 const (
@@ -292,14 +293,6 @@ func (s *state) SetRemoteKey(remoteKey cipherset.Key) error {
 	return cipherset.ErrInvalidKey
 }
 
-func (s *state) setRemoteLineKey(k *key) {
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
-
-	s.remoteLineKey = k
-	s.update()
-}
-
 func (s *state) update() {
 
 	if s.nonce == nil {
@@ -494,6 +487,9 @@ func (s *state) ApplyHandshake(h cipherset.Handshake) bool {
 		return false
 	}
 
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
 	if s.remoteKey != nil && *s.remoteKey.pub != *hs.key.pub {
 		return false
 	}
@@ -505,13 +501,58 @@ func (s *state) ApplyHandshake(h cipherset.Handshake) bool {
 		s.lineEncryptionKey = nil
 	}
 
-	s.setRemoteLineKey(hs.lineKey)
-	if s.remoteKey == nil {
-		s.SetRemoteKey(hs.key)
+	s.remoteLineKey = hs.lineKey
+	if s.remoteKey == nil && hs.key != nil && hs.key.CanEncrypt() {
+		s.remoteKey = hs.key
 	}
+	s.update()
 	return true
 }
 
+// MarshalState returns the local and remote line keys.
+func (s *state) MarshalState() ([]byte, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if s.localLineKey == nil || s.localLineKey.prv == nil || s.remoteLineKey == nil {
+		return nil, cipherset.ErrInvalidState
+	}
+
+	p := make([]byte, 0, 3*lenKey)
+	p = append(p, (*s.localLineKey.prv)[:]...)
+	p = append(p, (*s.localLineKey.pub)[:]...)
+	p = append(p, (*s.remoteLineKey.pub)[:]...)
+	return p, nil
+}
+
+// UnmarshalState restores the line keys returned by MarshalState. The packet
+// nonces are regenerated so they are never reused.
+func (s *state) UnmarshalState(p []byte) error {
+	if len(p) != 3*lenKey {
+		return cipherset.ErrInvalidState
+	}
+
+	var prv, pub, remotePub [lenKey]byte
+	copy(prv[:], p[:lenKey])
+	copy(pub[:], p[lenKey:2*lenKey])
+	copy(remotePub[:], p[2*lenKey:])
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.localLineKey = makeKey(&prv, &pub)
+	s.remoteLineKey = makeKey(nil, &remotePub)
+	s.localToken = nil
+	s.remoteToken = nil
+	s.lineEncryptionKey = nil
+	s.lineDecryptionKey = nil
+	s.nonce = nil
+	s.pktNoncePrefix = nil
+	s.pktNonceSuffix = 0
+	s.update()
+	return nil
+}
+
 func (s *state) EncryptPacket(pkt *lob.Packet) (*lob.Packet, error) {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
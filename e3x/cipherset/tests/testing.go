@@ -241,6 +241,64 @@ func (s *cipherTestSuite) TestPacketEncryption() {
 	assert.Equal([]byte("Bye world!"), pkt.Body(nil))
 }
 
+func (s *cipherTestSuite) TestStateMarshaling() {
+	var (
+		assert = s.Assertions
+		c      = s.cipher
+	)
+
+	ka, err := c.GenerateKey()
+	assert.NoError(err)
+	kb, err := c.GenerateKey()
+	assert.NoError(err)
+
+	sa, err := c.NewState(ka)
+	assert.NoError(err)
+	if _, ok := sa.(cipherset.StateMarshaler); !ok {
+		s.T().Skip("state marshaling is not supported")
+	}
+	sb, err := c.NewState(kb)
+	assert.NoError(err)
+
+	assert.NoError(sa.SetRemoteKey(kb))
+	box, err := sa.EncryptHandshake(1, nil)
+	assert.NoError(err)
+	hb, err := c.DecryptHandshake(kb, box)
+	assert.NoError(err)
+	assert.True(sb.ApplyHandshake(hb))
+	box, err = sb.EncryptHandshake(1, nil)
+	assert.NoError(err)
+	ha, err := c.DecryptHandshake(ka, box)
+	assert.NoError(err)
+	assert.True(sa.ApplyHandshake(ha))
+
+	p, err := sa.(cipherset.StateMarshaler).MarshalState()
+	assert.NoError(err)
+
+	sc, err := c.NewState(ka)
+	assert.NoError(err)
+	assert.NoError(sc.SetRemoteKey(kb))
+	assert.NoError(sc.(cipherset.StateMarshaler).UnmarshalState(p))
+	assert.Equal(sa.LocalToken(), sc.LocalToken())
+	assert.Equal(sa.RemoteToken(), sc.RemoteToken())
+	assert.True(sc.CanEncryptPacket())
+	assert.True(sc.CanDecryptPacket())
+
+	pkt, err := sc.EncryptPacket(lob.New([]byte("Hello again!")))
+	assert.NoError(err)
+	pkt, err = sb.DecryptPacket(pkt)
+	assert.NoError(err)
+	assert.Equal([]byte("Hello again!"), pkt.Body(nil))
+
+	pkt, err = sb.EncryptPacket(lob.New([]byte("Welcome back!")))
+	assert.NoError(err)
+	pkt, err = sc.DecryptPacket(pkt)
+	assert.NoError(err)
+	assert.Equal([]byte("Welcome back!"), pkt.Body(nil))
+
+	assert.Equal(cipherset.ErrInvalidState, sc.(cipherset.StateMarshaler).UnmarshalState(p[1:]))
+}
+
 func BenchmarkPacketEncryption(b *testing.B, c cipherset.Cipher) {
 	pkt := lob.New(bytes.Repeat([]byte{'x'}, 1024))
 
@@ -0,0 +1,40 @@
+package e3x
+
+import (
+	"context"
+	"sync"
+)
+
+// waitContext waits on cnd for as long as block returns true. It returns
+// ctx.Err() when ctx is done before block returned false. The lock of cnd
+// must be held by the caller.
+func waitContext(ctx context.Context, cnd *sync.Cond, block func() bool) error {
+	if ctx.Done() == nil {
+		for block() {
+			cnd.Wait()
+		}
+		return nil
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			cnd.L.Lock()
+			cnd.Broadcast()
+			cnd.L.Unlock()
+		case <-done:
+		}
+	}()
+
+	for block() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cnd.Wait()
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package e3x
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestAcceptChannelContext(t *testing.T) {
+	assert := assert.New(t)
+
+	withEndpoint(t, func(A *Endpoint) {
+		l := A.Listen("ctx", true)
+		defer l.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		c, err := l.AcceptChannelContext(ctx)
+		assert.Nil(c)
+		assert.Equal(context.DeadlineExceeded, err)
+	})
+}
+
+func TestDialContext(t *testing.T) {
+	assert := assert.New(t)
+
+	var ident *Identity
+
+	withEndpoint(t, func(C *Endpoint) {
+		var err error
+		ident, err = C.LocalIdentity()
+		assert.NoError(err)
+	})
+
+	withEndpoint(t, func(A *Endpoint) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		x, err := A.DialContext(ctx, ident)
+		assert.Nil(x)
+		assert.Equal(context.Canceled, err)
+	})
+}
+
+func TestChannelContext(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("ctx", false).AcceptChannelContext(context.Background())
+			if assert.NoError(err) && assert.NotNil(c) {
+				c.SetDeadline(time.Now().Add(10 * time.Second))
+
+				pkt, err := c.ReadPacket()
+				if assert.NoError(err) {
+					pkt.Free()
+				}
+
+				// nothing to read yet
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				pkt, err = c.ReadPacketContext(ctx)
+				cancel()
+				assert.Nil(pkt)
+				assert.Equal(context.DeadlineExceeded, err)
+
+				assert.NoError(c.WritePacketContext(context.Background(), lob.New(nil)))
+				assert.NoError(c.Close())
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.OpenContext(context.Background(), ident, "ctx", false)
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		assert.NoError(c.WritePacket(lob.New(nil)))
+
+		// the second write blocks until the peer responded (unreliable channels
+		// are never acked)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err = c.WritePacketContext(ctx, lob.New(nil))
+		cancel()
+		assert.Equal(context.DeadlineExceeded, err)
+
+		_, err = c.ReadPacketContext(context.Background())
+		assert.NoError(err)
+		assert.NoError(c.Close())
+		<-done
+	})
+}
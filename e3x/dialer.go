@@ -0,0 +1,106 @@
+package e3x
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+// Network is the name of the telehash network (see Addr and Dialer).
+const Network = "telehash"
+
+var (
+	_ net.Addr = (*Addr)(nil)
+)
+
+// Addr is the address of a channel or a listener: the hashname of an
+// endpoint and the channel type (or type pattern).
+type Addr struct {
+	Hashname hashname.H
+	Type     string
+}
+
+// Network returns "telehash".
+func (a *Addr) Network() string { return Network }
+
+// String returns the hashname.
+func (a *Addr) String() string { return string(a.Hashname) }
+
+// ParseAddr parses a hashname. A port suffix (as added by net/http) is
+// ignored.
+func ParseAddr(address string) (*Addr, error) {
+	hn := address
+	if strings.Contains(address, ":") {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, &net.AddrError{Err: err.Error(), Addr: address}
+		}
+		hn = host
+	}
+
+	if !hashname.H(hn).Valid() {
+		return nil, &net.AddrError{Err: "invalid hashname", Addr: address}
+	}
+
+	return &Addr{Hashname: hashname.H(hn)}, nil
+}
+
+// Dialer opens reliable channels of type Type. Its DialContext method has
+// the signature of net.Dialer.DialContext, which lets net/http, gRPC and
+// other clients run over telehash:
+//
+//	d := &e3x.Dialer{Endpoint: e, Type: "http"}
+//	c := &http.Client{Transport: &http.Transport{DialContext: d.DialContext}}
+//	c.Get("http://" + hashname + "/")
+//
+// The peer must be known to the endpoint (see HashnameIdentifier). The
+// server side uses the Listener returned by Endpoint.Listen.
+type Dialer struct {
+	Endpoint *Endpoint
+	Type     string
+	Options  []ChannelOption
+}
+
+// Dial opens a channel to the hashname address on the telehash network.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext is like Dial but gives up when ctx is done. The returned
+// net.Conn is a *Channel. As clients like net/http always dial "tcp", the
+// tcp networks are accepted as well.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	c, err := d.DialChannel(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DialChannel is like DialContext but returns the *Channel.
+func (d *Dialer) DialChannel(ctx context.Context, network, address string) (*Channel, error) {
+	switch network {
+	case Network, "tcp", "tcp4", "tcp6":
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+
+	addr, err := ParseAddr(address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	addr.Type = d.Type
+
+	if d.Type == "" {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: addr, Err: ErrMissingChannelType}
+	}
+
+	c, err := d.Endpoint.OpenContext(ctx, HashnameIdentifier(addr.Hashname), d.Type, true, d.Options...)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: addr, Err: err}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,106 @@
+package e3x
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestParseAddr(t *testing.T) {
+	assert := assert.New(t)
+
+	const hn = "jvdoio6kjvf5yqnxfvys3g5tfwmjcmb6qt5xzx2yunygmvlrlnyq"
+
+	addr, err := ParseAddr(hn)
+	if assert.NoError(err) {
+		assert.Equal(&Addr{Hashname: hn}, addr)
+		assert.Equal("telehash", addr.Network())
+		assert.Equal(hn, addr.String())
+	}
+
+	addr, err = ParseAddr(hn + ":80")
+	if assert.NoError(err) {
+		assert.Equal(&Addr{Hashname: hn}, addr)
+	}
+
+	_, err = ParseAddr("example.com:80")
+	assert.IsType(&net.AddrError{}, err)
+}
+
+func TestDialer(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		assert := assert.New(t)
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		// make A known to B
+		_, err = B.Dial(ident)
+		if !assert.NoError(err) {
+			return
+		}
+
+		l := A.Listen("http", true)
+		assert.Equal(&Addr{Hashname: A.LocalHashname(), Type: "http"}, l.Addr())
+
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(req.RemoteAddr))
+		})}
+		done := make(chan error, 1)
+		go func() { done <- srv.Serve(l) }()
+
+		d := &Dialer{Endpoint: B, Type: "http"}
+		client := &http.Client{
+			Transport: &http.Transport{DialContext: d.DialContext},
+			Timeout:   10 * time.Second,
+		}
+
+		for i := 0; i < 2; i++ {
+			resp, err := client.Get("http://" + string(A.LocalHashname()) + "/")
+			if !assert.NoError(err) {
+				break
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.NoError(err)
+			assert.Equal(string(B.LocalHashname()), string(body))
+		}
+
+		conn, err := d.Dial("udp", string(A.LocalHashname()))
+		assert.Nil(conn)
+		if assert.IsType(&net.OpError{}, err) {
+			assert.IsType(net.UnknownNetworkError(""), err.(*net.OpError).Err)
+		}
+
+		c, err := d.DialChannel(context.Background(), Network, string(A.LocalHashname()))
+		if assert.NoError(err) {
+			assert.Equal(&Addr{Hashname: A.LocalHashname(), Type: "http"}, c.RemoteAddr())
+			assert.Equal(&Addr{Hashname: B.LocalHashname(), Type: "http"}, c.LocalAddr())
+			c.Kill()
+		}
+
+		client.Transport.(*http.Transport).CloseIdleConnections()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		assert.Equal(http.ErrServerClosed, <-done)
+
+		_, err = l.Accept()
+		assert.True(errors.Is(err, net.ErrClosed))
+	})
+}
+
+func TestChannelAddrWithoutExchange(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Channel{typ: "http", hashname: "peer"}
+	assert.Equal(&Addr{Type: "http"}, c.LocalAddr())
+	assert.Equal(&Addr{Hashname: "peer", Type: "http"}, c.RemoteAddr())
+}
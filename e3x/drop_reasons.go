@@ -0,0 +1,78 @@
+package e3x
+
+// DropError is the reason a packet was dropped. It is passed to the
+// OnDropPacket hooks of endpoints, exchanges and channels. Reason is the key of
+// the drop in the Drops statistics.
+type DropError struct {
+	Reason string
+}
+
+func (err *DropError) Error() string {
+	return "e3x: " + err.Reason
+}
+
+// dropReason returns the key of reason in the Drops statistics.
+func dropReason(reason error) string {
+	if err, ok := reason.(*DropError); ok {
+		return err.Reason
+	}
+	return reason.Error()
+}
+
+// Reasons for dropping packets before they reach an exchange.
+var (
+	ErrTooShort             = &DropError{"packet too short"}
+	ErrUnknownToken         = &DropError{"unknown token"}
+	ErrUnknownCSID          = &DropError{"unknown csid"}
+	ErrInvalidHandshake     = &DropError{"invalid handshake"}
+	ErrNoLocalIdentity      = &DropError{"no local identity"}
+	ErrHandshakeRateLimited = &DropError{"handshake rate limited"}
+	ErrCookieRequired       = &DropError{"handshake cookie required"}
+	ErrInvalidCookie        = &DropError{"invalid handshake cookie"}
+)
+
+// Reasons for refusing exchanges and channels.
+var (
+	ErrAccessDenied     = &DropError{"access denied"}
+	ErrTooManyExchanges = &DropError{"too many exchanges"}
+	ErrTooManyChannels  = &DropError{"too many channels"}
+	ErrShuttingDown     = &DropError{"shutting down"}
+)
+
+// Reasons for dropping channels which were not accepted by a listener.
+var (
+	ErrListenerClosed          = &DropError{"listener closed"}
+	ErrListenerBacklogTooLarge = &DropError{"listener backlog too large"}
+	ErrListenerInvalidType     = &DropError{"listener invalid channel type"}
+	ErrListenerUnknownType     = &DropError{"listener unknown channel type"}
+	ErrListenerAcceptTimeout   = &DropError{"listener accept timeout"}
+)
+
+// Reasons for dropping packets and handshakes received by an exchange.
+var (
+	ErrInvalidPacket         = &DropError{"invalid lob packet"}
+	ErrInvalidCiphertext     = &DropError{"invalid ciphertext"}
+	ErrExchangeNotOpen       = &DropError{"exchange is not open"}
+	ErrReplay                = &DropError{"replayed handshake"}
+	ErrRejectedHandshake     = &DropError{"rejected handshake"}
+	ErrMissingChannelID      = &DropError{"missing channel id header"}
+	ErrMissingChannelType    = &DropError{"missing channel type header"}
+	ErrMissingChannelHandler = &DropError{"missing channel handler"}
+	ErrUnknownControlPacket  = &DropError{"unknown control packet"}
+)
+
+// Reasons for dropping packets sent by an exchange. These are counted in the
+// Drops statistics but are not passed to the OnDropPacket hooks.
+var (
+	ErrSendQueueFull = &DropError{"send queue full"}
+)
+
+// Reasons for dropping packets of a channel.
+var (
+	ErrBrokenChannel   = &DropError{"broken channel"}
+	ErrMissingSeq      = &DropError{"missing seq"}
+	ErrDuplicatePacket = &DropError{"duplicate packet"}
+	ErrBufferFull      = &DropError{"full buffer"}
+	ErrExpired         = &DropError{"expired"}
+	ErrInvalidFragment = &DropError{"invalid fragment"}
+)
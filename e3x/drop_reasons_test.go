@@ -0,0 +1,95 @@
+package e3x
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestEndpointDropReasons(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	laddr := l.LocalAddr().String()
+	l.Close()
+
+	drops := make(chan error, 1)
+	A, err := Open(
+		Transport(udp.Config{Network: "udp4", Addr: laddr}),
+		DisableLog(),
+		withEndpointHook(EndpointHook{
+			OnDropPacket: func(e *Endpoint, msg []byte, conn net.Conn, reason error) error {
+				drops <- reason
+				return nil
+			},
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer A.Close()
+
+	conn, err := net.Dial("udp4", laddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	garbage := make([]byte, 64)
+	tests := []struct {
+		msg    []byte
+		reason error
+	}{
+		{[]byte{0}, ErrTooShort},
+		{append([]byte{0, 0}, garbage...), ErrUnknownToken},
+		{append([]byte{0, 1, 0xff}, garbage...), ErrUnknownCSID},
+		{append([]byte{0, 1, 0x3a}, garbage...), ErrInvalidHandshake},
+	}
+
+	for _, test := range tests {
+		_, err := conn.Write(test.msg)
+		assert.NoError(err)
+
+		select {
+		case reason := <-drops:
+			assert.Equal(test.reason, reason)
+		case <-time.After(time.Second):
+			t.Errorf("no drop for %v", test.reason)
+		}
+	}
+
+	stats := A.Stats()
+	for _, test := range tests {
+		assert.Equal(uint64(1), stats.Drops[dropReason(test.reason)])
+	}
+}
+
+func TestChannelDropReasons(t *testing.T) {
+	assert := assert.New(t)
+
+	var drops []error
+
+	c := &Channel{}
+	c.channelHooks.Register(ChannelHook{
+		OnDropPacket: func(e *Endpoint, x *Exchange, c *Channel, pkt *lob.Packet, reason error) error {
+			drops = append(drops, reason)
+			return nil
+		},
+	})
+
+	// a fragment without an index
+	pkt := lob.New([]byte("fragment"))
+	pkt.Header().SetUint32("mid", 1)
+	_, done := c.reassembleMessage(pkt)
+	assert.False(done)
+
+	assert.Equal([]error{ErrInvalidFragment}, drops)
+	assert.Equal(uint64(1), c.Stats().Drops[ErrInvalidFragment.Reason])
+}
@@ -1,6 +1,7 @@
 package e3x
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -47,9 +48,17 @@ type Endpoint struct {
 	exchangeHooks ExchangeHooks
 	channelHooks  ChannelHooks
 
-	tokens      map[cipherset.Token]*Exchange
-	hashnames   map[hashname.H]*Exchange
-	listenerSet *listenerSet
+	tokens          map[cipherset.Token]*Exchange
+	hashnames       map[hashname.H]*Exchange
+	listenerSet     *listenerSet
+	exchangeOptions []ExchangeOption
+	maxExchanges    int
+	evictions       uint64
+	stats           counters      // packets dropped by the endpoint
+	closedStats     ExchangeStats // counters of closed exchanges
+	guard           *handshakeGuard
+	draining        int32
+	access          accessControl
 }
 
 type EndpointOption func(e *Endpoint) error
@@ -64,11 +73,14 @@ func Open(options ...EndpointOption) (*Endpoint, error) {
 
 	e.listenerSet = newListenerSet()
 	e.listenerSet.addrFunc = func() net.Addr {
-		return e.LocalHashname()
+		return &Addr{Hashname: e.LocalHashname()}
 	}
 	e.listenerSet.dropChannelFunc = func(c *Channel, reason error) {
-		c.Kill()
+		if c.Reject(rejectCodeFor(reason), reason.Error()) != nil {
+			c.Kill()
+		}
 	}
+	e.listenerSet.admitChannelFunc = e.admitChannel
 
 	e.endpointHooks.endpoint = e
 	e.exchangeHooks.endpoint = e
@@ -77,7 +89,8 @@ func Open(options ...EndpointOption) (*Endpoint, error) {
 
 	err := e.setOptions(
 		RegisterModule(modTransportsKey, &modTransports{e}),
-		RegisterModule(modNetwatchKey, &modNetwatch{endpoint: e}))
+		RegisterModule(modNetwatchKey, &modNetwatch{endpoint: e}),
+		RegisterModule(modChannelMuxKey, &ChannelMux{e: e}))
 	if err != nil {
 		return nil, e.traceError(err)
 	}
@@ -267,6 +280,19 @@ func Log(w io.Writer) EndpointOption {
 	}
 }
 
+// DefaultExchangeOptions sets options which are applied to every exchange of
+// the endpoint (for example Multipath).
+func DefaultExchangeOptions(options ...ExchangeOption) EndpointOption {
+	return func(e *Endpoint) error {
+		e.exchangeOptions = append(e.exchangeOptions, options...)
+		return nil
+	}
+}
+
+func (e *Endpoint) newExchangeOptions() []ExchangeOption {
+	return append([]ExchangeOption{registerEndpoint(e)}, e.exchangeOptions...)
+}
+
 func DisableLog() EndpointOption {
 	return func(e *Endpoint) error {
 		e.log = nil
@@ -298,9 +324,10 @@ func defaultTransport(e *Endpoint) error {
 	})(e)
 }
 
-// Listen makes a new channel listener.
-func (e *Endpoint) Listen(typ string, reliable bool) *Listener {
-	return e.listenerSet.Listen(typ, reliable)
+// Listen makes a new channel listener. typ may be a pattern; see ChannelMux
+// for the matching rules.
+func (e *Endpoint) Listen(typ string, reliable bool, options ...ListenerOption) *Listener {
+	return e.listenerSet.Listen(typ, reliable, options...)
 }
 
 func (e *Endpoint) LocalHashname() hashname.H {
@@ -402,10 +429,11 @@ func (e *Endpoint) acceptConnections() {
 
 func (e *Endpoint) accept(conn net.Conn) {
 	var (
-		token cipherset.Token
-		msg   = bufpool.New()
-		err   error
-		n     int
+		token  cipherset.Token
+		msg    = bufpool.New()
+		cookie string
+		err    error
+		n      int
 	)
 	n, err = conn.Read(msg.RawBytes()[:1500])
 	if err != nil {
@@ -421,13 +449,29 @@ func (e *Endpoint) accept(conn net.Conn) {
 	// always associate the conn with the exchange
 
 	if msg.Len() < 2 {
-		if e.endpointHooks.DropPacket(msg.Get(nil), conn, nil) != ErrStopPropagation {
+		if e.dropPacket(msg.Get(nil), conn, ErrTooShort) != ErrStopPropagation {
 			conn.Close()
 		}
 		msg.Free()
 		return // to short
 	}
 
+	if isCookiePacket(msg.RawBytes()) {
+		// a retry or a handshake with a cookie
+		inner, c, err := e.receivedCookiePacket(msg, conn)
+		if err != nil {
+			if e.dropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
+				conn.Close()
+			}
+			e.traceDroppedPacket(msg.Get(nil), conn, dropReason(err))
+		}
+		msg.Free()
+		if inner == nil {
+			return
+		}
+		msg, cookie = inner, c
+	}
+
 	token = cipherset.ExtractToken(msg.RawBytes())
 	e.mtx.Lock()
 	exchange := e.tokens[token]
@@ -439,16 +483,28 @@ func (e *Endpoint) accept(conn net.Conn) {
 	}
 
 	if raw := msg.RawBytes(); len(raw) < 3 || raw[0] != 0 || raw[1] != 1 {
-		if e.endpointHooks.DropPacket(msg.Get(nil), conn, nil) != ErrStopPropagation {
+		if e.dropPacket(msg.Get(nil), conn, ErrUnknownToken) != ErrStopPropagation {
 			conn.Close()
 		}
 		msg.Free()
-		return // to short
+		return // not a handshake
+	}
+
+	if err := e.guard.admit(conn.RemoteAddr(), cookie); err != nil {
+		if err == ErrCookieRequired {
+			e.sendRetry(conn, token)
+		}
+		if e.dropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
+			conn.Close()
+		}
+		e.traceDroppedPacket(msg.Get(nil), conn, dropReason(err))
+		msg.Free()
+		return // drop
 	}
 
 	localIdent, err := e.LocalIdentity()
 	if err != nil {
-		if e.endpointHooks.DropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
+		if e.dropPacket(msg.Get(nil), conn, ErrNoLocalIdentity) != ErrStopPropagation {
 			conn.Close()
 		}
 		e.traceDroppedPacket(msg.Get(nil), conn, err.Error())
@@ -465,7 +521,7 @@ func (e *Endpoint) accept(conn net.Conn) {
 		key  = e.keys[csid]
 	)
 	if key == nil {
-		if e.endpointHooks.DropPacket(msg.Get(nil), conn, nil) != ErrStopPropagation {
+		if e.dropPacket(msg.Get(nil), conn, ErrUnknownCSID) != ErrStopPropagation {
 			conn.Close()
 		}
 		msg.Free()
@@ -474,7 +530,7 @@ func (e *Endpoint) accept(conn net.Conn) {
 
 	handshake, err := cipherset.DecryptHandshake(csid, key, msg.RawBytes()[3:])
 	if err != nil {
-		if e.endpointHooks.DropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
+		if e.dropPacket(msg.Get(nil), conn, ErrInvalidHandshake) != ErrStopPropagation {
 			conn.Close()
 		}
 		e.traceDroppedPacket(msg.Get(nil), conn, err.Error())
@@ -485,7 +541,7 @@ func (e *Endpoint) accept(conn net.Conn) {
 	hn, err := hashname.FromKeyAndIntermediates(csid,
 		handshake.PublicKey().Public(), handshake.Parts())
 	if err != nil {
-		if e.endpointHooks.DropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
+		if e.dropPacket(msg.Get(nil), conn, ErrInvalidHandshake) != ErrStopPropagation {
 			conn.Close()
 		}
 		e.traceDroppedPacket(msg.Get(nil), conn, err.Error())
@@ -495,28 +551,42 @@ func (e *Endpoint) accept(conn net.Conn) {
 
 	exchange = e.hashnames[hn]
 	if exchange != nil {
-		oldLocalToken := exchange.LocalToken()
-		oldRemoteToken := exchange.RemoteToken()
+		oldTokens := exchange.tokens()
 		exchange.received(newMessage(msg, newPipe(e.transport, conn, nil, exchange)))
-		newLocalToken := exchange.LocalToken()
-		newRemoteToken := exchange.RemoteToken()
+		e.updateTokens(exchange, oldTokens)
+		return
+	}
 
-		if oldLocalToken != newLocalToken {
-			delete(e.tokens, oldLocalToken)
-			e.tokens[newLocalToken] = exchange
+	if !e.AccessPolicy().AllowExchange(hn) {
+		if e.dropPacket(msg.Get(nil), conn, ErrAccessDenied) != ErrStopPropagation {
+			conn.Close()
 		}
+		e.traceDroppedPacket(msg.Get(nil), conn, ErrAccessDenied.Reason)
+		msg.Free()
+		return // drop
+	}
 
-		if oldRemoteToken != newRemoteToken {
-			delete(e.tokens, oldRemoteToken)
-			e.tokens[newRemoteToken] = exchange
+	if e.isDraining() {
+		if e.dropPacket(msg.Get(nil), conn, ErrShuttingDown) != ErrStopPropagation {
+			conn.Close()
 		}
+		e.traceDroppedPacket(msg.Get(nil), conn, ErrShuttingDown.Reason)
+		msg.Free()
+		return // drop
+	}
 
-		return
+	if !e.reserveExchange() {
+		if e.dropPacket(msg.Get(nil), conn, ErrTooManyExchanges) != ErrStopPropagation {
+			conn.Close()
+		}
+		e.traceDroppedPacket(msg.Get(nil), conn, ErrTooManyExchanges.Reason)
+		msg.Free()
+		return // drop
 	}
 
-	exchange, err = newExchange(localIdent, nil, handshake, e.log, registerEndpoint(e))
+	exchange, err = newExchange(localIdent, nil, handshake, e.log, e.newExchangeOptions()...)
 	if err != nil {
-		if e.endpointHooks.DropPacket(msg.Get(nil), conn, err) != ErrStopPropagation {
+		if e.dropPacket(msg.Get(nil), conn, ErrInvalidHandshake) != ErrStopPropagation {
 			conn.Close()
 		}
 		e.traceDroppedPacket(msg.Get(nil), conn, err.Error())
@@ -527,22 +597,73 @@ func (e *Endpoint) accept(conn net.Conn) {
 	e.hashnames[hn] = exchange
 	e.tokens[exchange.LocalToken()] = exchange
 	e.tokens[exchange.RemoteToken()] = exchange
-	exchange.state = ExchangeDialing
+	exchange.mtx.Lock()
+	exchange.setState(ExchangeDialing)
+	exchange.mtx.Unlock()
 	exchange.received(newMessage(msg, newPipe(e.transport, conn, nil, exchange)))
 }
 
 func (e *Endpoint) onExchangeClosed(_ *Endpoint, x *Exchange, reason error) error {
+	xs := x.Stats()
+
 	e.mtx.Lock()
 	defer e.mtx.Unlock()
 
+	e.unregisterExchange(x)
+	e.closedStats.add(xs)
+	return nil
+}
+
+// unregisterExchange removes x from the exchange indexes unless it was
+// already replaced by another exchange. The endpoint must be locked.
+func (e *Endpoint) unregisterExchange(x *Exchange) {
 	if x.remoteIdent != nil {
-		delete(e.hashnames, x.remoteIdent.Hashname())
+		hn := x.remoteIdent.Hashname()
+		if e.hashnames[hn] == x {
+			delete(e.hashnames, hn)
+		}
 	}
 
-	delete(e.tokens, x.LocalToken())
-	delete(e.tokens, x.RemoteToken())
+	for _, token := range x.tokens() {
+		if e.tokens[token] == x {
+			delete(e.tokens, token)
+		}
+	}
+}
 
-	return nil
+// exchangeTokensChanged updates the token index after x rotated its line keys.
+func (e *Endpoint) exchangeTokensChanged(x *Exchange, old []cipherset.Token) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	e.updateTokens(x, old)
+}
+
+// updateTokens indexes the tokens of x and removes the tokens in old which x
+// no longer uses. The endpoint must be locked.
+func (e *Endpoint) updateTokens(x *Exchange, old []cipherset.Token) {
+	current := x.tokens()
+
+	for _, token := range old {
+		if !containsToken(current, token) && e.tokens[token] == x {
+			delete(e.tokens, token)
+		}
+	}
+
+	for _, token := range current {
+		if token != cipherset.ZeroToken {
+			e.tokens[token] = x
+		}
+	}
+}
+
+func containsToken(tokens []cipherset.Token, token cipherset.Token) bool {
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
 }
 
 // func (e *Endpoint) received(op opRead) {
@@ -682,6 +803,12 @@ func (e *Endpoint) Identify(i Identifier) (*Identity, error) {
 // Dial will lookup the identity of identifier, get the exchange for the identity
 // and dial the exchange.
 func (e *Endpoint) Dial(identifier Identifier) (*Exchange, error) {
+	return e.DialContext(context.Background(), identifier)
+}
+
+// DialContext is like Dial but returns ctx.Err() when ctx is done before the
+// exchange is open.
+func (e *Endpoint) DialContext(ctx context.Context, identifier Identifier) (*Exchange, error) {
 	if identifier == nil || e == nil {
 		return nil, os.ErrInvalid
 	}
@@ -702,7 +829,7 @@ func (e *Endpoint) Dial(identifier Identifier) (*Exchange, error) {
 		return nil, err
 	}
 
-	err = x.Dial()
+	err = x.DialContext(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -738,6 +865,7 @@ func (e *Endpoint) CreateExchange(identity *Identity) (*Exchange, error) {
 
 	// Check for existing exchange
 	if x, found := e.hashnames[identity.hashname]; found && x != nil {
+		x.local = true
 		return x, nil
 	}
 
@@ -748,6 +876,14 @@ func (e *Endpoint) CreateExchange(identity *Identity) (*Exchange, error) {
 		err error
 	)
 
+	if e.isDraining() {
+		return nil, ErrShuttingDown
+	}
+
+	if !e.reserveExchange() {
+		return nil, ErrTooManyExchanges
+	}
+
 	// Get local identity
 	localIdent, err = e.LocalIdentity()
 	if err != nil {
@@ -755,12 +891,13 @@ func (e *Endpoint) CreateExchange(identity *Identity) (*Exchange, error) {
 	}
 
 	// Make a new exchange struct
-	x, err = newExchange(localIdent, identity, nil, e.log, registerEndpoint(e))
+	x, err = newExchange(localIdent, identity, nil, e.log, e.newExchangeOptions()...)
 	if err != nil {
 		return nil, err
 	}
 
 	// register the new exchange
+	x.local = true
 	e.tokens[x.LocalToken()] = x
 	e.hashnames[identity.hashname] = x
 
@@ -0,0 +1,215 @@
+package e3x
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+)
+
+// AccessAction is the action of an access rule.
+type AccessAction string
+
+const (
+	AccessAllow AccessAction = "allow"
+	AccessDeny  AccessAction = "deny"
+)
+
+// AccessRule matches a peer and a channel type.
+type AccessRule struct {
+	Action   AccessAction `json:"action"`
+	Hashname hashname.H   `json:"hashname,omitempty"` // the peer; empty or "*" matches every peer
+	Type     string       `json:"type,omitempty"`     // a channel type pattern (see ChannelMux); empty matches every type
+}
+
+// AccessPolicy decides which peers may open exchanges and channels. The
+// rules are evaluated in order and the first matching rule decides; when no
+// rule matches the Default action applies (allow when empty).
+//
+// A peer may open an exchange when the first rule for the peer without a
+// type allows it, or when a rule which allows one of the channel types comes
+// first. Access policies are stored as JSON:
+//
+//	{"default": "deny", "rules": [
+//	  {"action": "allow", "hashname": "...", "type": "chat/*"}
+//	]}
+type AccessPolicy struct {
+	Default AccessAction `json:"default,omitempty"`
+	Rules   []AccessRule `json:"rules"`
+}
+
+// LoadAccessPolicy reads an access policy from a JSON file.
+func LoadAccessPolicy(path string) (*AccessPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p AccessPolicy
+	err = json.Unmarshal(data, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (p *AccessPolicy) validate() error {
+	if p.Default != "" && p.Default != AccessAllow && p.Default != AccessDeny {
+		return fmt.Errorf("e3x: invalid default access action %q", p.Default)
+	}
+
+	for i, r := range p.Rules {
+		if r.Action != AccessAllow && r.Action != AccessDeny {
+			return fmt.Errorf("e3x: invalid action %q in access rule %d", r.Action, i)
+		}
+		if r.Hashname != "" && r.Hashname != "*" && !r.Hashname.Valid() {
+			return fmt.Errorf("e3x: invalid hashname %q in access rule %d", r.Hashname, i)
+		}
+	}
+
+	return nil
+}
+
+func (r *AccessRule) matchPeer(hn hashname.H) bool {
+	return r.Hashname == "" || r.Hashname == "*" || r.Hashname == hn
+}
+
+// AllowExchange reports whether the peer hn may open an exchange.
+func (p *AccessPolicy) AllowExchange(hn hashname.H) bool {
+	if p == nil {
+		return true
+	}
+
+	for _, r := range p.Rules {
+		if !r.matchPeer(hn) {
+			continue
+		}
+		if r.Type == "" {
+			return r.Action == AccessAllow
+		}
+		if r.Action == AccessAllow {
+			return true
+		}
+	}
+
+	return p.Default != AccessDeny
+}
+
+// AllowChannel reports whether the peer hn may open a channel of type typ.
+func (p *AccessPolicy) AllowChannel(hn hashname.H, typ string) bool {
+	if p == nil {
+		return true
+	}
+
+	for _, r := range p.Rules {
+		if r.matchPeer(hn) && (r.Type == "" || matchType(r.Type, typ)) {
+			return r.Action == AccessAllow
+		}
+	}
+
+	return p.Default != AccessDeny
+}
+
+type accessControl struct {
+	mtx    sync.RWMutex
+	policy *AccessPolicy
+	path   string
+}
+
+// Access sets the access policy of the endpoint. It replaces a policy file set
+// by an earlier AccessPolicyFile option.
+func Access(p *AccessPolicy) EndpointOption {
+	return func(e *Endpoint) error {
+		if p != nil {
+			if err := p.validate(); err != nil {
+				return err
+			}
+		}
+
+		e.access.policy = p
+		e.access.path = ""
+		return nil
+	}
+}
+
+// AccessPolicyFile loads the access policy of the endpoint from a JSON file.
+// The file is read again by Endpoint.ReloadAccessPolicy.
+func AccessPolicyFile(path string) EndpointOption {
+	return func(e *Endpoint) error {
+		p, err := LoadAccessPolicy(path)
+		if err != nil {
+			return err
+		}
+
+		e.access.policy = p
+		e.access.path = path
+		return nil
+	}
+}
+
+// AccessPolicy returns the current access policy (nil allows everything).
+func (e *Endpoint) AccessPolicy() *AccessPolicy {
+	e.access.mtx.RLock()
+	defer e.access.mtx.RUnlock()
+	return e.access.policy
+}
+
+// SetAccessPolicy replaces the access policy. Exchanges with peers which are
+// no longer allowed are broken together with all their channels. The channels
+// of peers which are still allowed stay open, even when their type is no
+// longer allowed; the policy only applies to new channels.
+func (e *Endpoint) SetAccessPolicy(p *AccessPolicy) error {
+	if p != nil {
+		if err := p.validate(); err != nil {
+			return err
+		}
+	}
+
+	e.access.mtx.Lock()
+	e.access.policy = p
+	e.access.mtx.Unlock()
+
+	for _, x := range e.GetExchanges() {
+		if !p.AllowExchange(x.RemoteHashname()) {
+			x.log.Println("\x1B[31mAccess denied\x1B[0m")
+			go x.onBreak()
+		}
+	}
+
+	return nil
+}
+
+// ReloadAccessPolicy reads the policy file (see AccessPolicyFile) again. The
+// current policy is kept when the file is invalid.
+func (e *Endpoint) ReloadAccessPolicy() error {
+	e.access.mtx.RLock()
+	path := e.access.path
+	e.access.mtx.RUnlock()
+
+	if path == "" {
+		return os.ErrInvalid
+	}
+
+	p, err := LoadAccessPolicy(path)
+	if err != nil {
+		return err
+	}
+
+	return e.SetAccessPolicy(p)
+}
+
+func (e *Endpoint) admitChannel(hn hashname.H, typ string) error {
+	if !e.AccessPolicy().AllowChannel(hn, typ) {
+		return ErrAccessDenied
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package e3x
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestAccessPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		a = hashname.H("jvdoio6kjvf5yqnxfvys3g5tfwmjcmb6qt5xzx2yunygmvlrlnyq")
+		b = hashname.H("xfvwmdexrsw5lxmaul5acuvmisgncmk5mxwsoqzbprhdfnmcxvca")
+	)
+
+	var p *AccessPolicy
+	assert.True(p.AllowExchange(a))
+	assert.True(p.AllowChannel(a, "admin"))
+
+	p = &AccessPolicy{
+		Default: AccessDeny,
+		Rules: []AccessRule{
+			{Action: AccessDeny, Hashname: a, Type: "chat/admin"},
+			{Action: AccessAllow, Hashname: a, Type: "chat/*"},
+			{Action: AccessDeny, Hashname: b},
+			{Action: AccessAllow, Type: "path"},
+		},
+	}
+	assert.NoError(p.validate())
+
+	assert.True(p.AllowExchange(a))
+	assert.True(p.AllowChannel(a, "chat/room"))
+	assert.False(p.AllowChannel(a, "chat/admin"))
+	assert.True(p.AllowChannel(a, "path"))
+	assert.False(p.AllowChannel(a, "admin"))
+
+	// the first rule for b denies everything
+	assert.False(p.AllowExchange(b))
+	assert.False(p.AllowChannel(b, "path"))
+
+	assert.Error((&AccessPolicy{Default: "maybe"}).validate())
+	assert.Error((&AccessPolicy{Rules: []AccessRule{{Action: "maybe"}}}).validate())
+	assert.Error((&AccessPolicy{Rules: []AccessRule{{Action: AccessAllow, Hashname: "foo"}}}).validate())
+}
+
+func TestEndpointAccessPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "e3x-access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	open := func(options ...EndpointOption) *Endpoint {
+		e, err := Open(append(options, Transport(inproc.Config{}), DisableLog())...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	B := open()
+	defer B.Close()
+	C := open()
+	defer C.Close()
+
+	path := filepath.Join(dir, "access.json")
+	writePolicy := func(action AccessAction) {
+		data := `{"default": "deny", "rules": [
+			{"action": "` + string(action) + `", "hashname": "` + string(B.LocalHashname()) + `", "type": "chat/*"}
+		]}`
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePolicy(AccessAllow)
+
+	var (
+		mtx   sync.Mutex
+		drops = map[error]int{}
+	)
+
+	A := open(AccessPolicyFile(path), withEndpointHook(EndpointHook{
+		OnDropPacket: func(e *Endpoint, msg []byte, conn net.Conn, reason error) error {
+			mtx.Lock()
+			drops[reason]++
+			mtx.Unlock()
+			return nil
+		},
+	}))
+	defer A.Close()
+
+	chat := A.Listen("chat/*", true)
+	defer chat.Close()
+	admin := A.Listen("admin", true)
+	defer admin.Close()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	// B may open chat channels
+	c, err := B.Open(ident, "chat/room", true)
+	if !assert.NoError(err) {
+		return
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	assert.NoError(c.WritePacket(lob.New(nil)))
+
+	d, err := chat.AcceptChannel()
+	if assert.NoError(err) {
+		d.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = d.ReadPacket()
+		assert.NoError(err)
+		go d.Close()
+	}
+	assert.NoError(c.Close())
+
+	// but no other channels
+	c, err = B.Open(ident, "admin", true)
+	if !assert.NoError(err) {
+		return
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	assert.NoError(c.WritePacket(lob.New(nil)))
+	_, err = c.ReadPacket()
+	if assert.IsType(&RejectedChannelError{}, err) {
+		assert.Equal(RejectRefused, err.(*RejectedChannelError).Code)
+	}
+
+	// C may not open an exchange
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = C.DialContext(ctx, ident)
+	assert.Equal(context.DeadlineExceeded, err)
+
+	mtx.Lock()
+	assert.True(drops[ErrAccessDenied] > 0)
+	mtx.Unlock()
+
+	// reloading a policy which denies B breaks the exchange
+	x := A.GetExchange(B.LocalHashname())
+	writePolicy(AccessDeny)
+	assert.NoError(A.ReloadAccessPolicy())
+	assert.False(A.AccessPolicy().AllowExchange(B.LocalHashname()))
+
+	if assert.NotNil(x) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		state, _ := x.WaitState(ctx, ExchangeBroken)
+		assert.Equal(ExchangeBroken, state)
+	}
+
+	// B has no policy file
+	assert.Equal(os.ErrInvalid, B.ReloadAccessPolicy())
+
+	// an explicit policy replaces an earlier policy file
+	allowAll := &AccessPolicy{Default: AccessAllow}
+	D := open(AccessPolicyFile(path), Access(allowAll))
+	defer D.Close()
+	assert.Equal(os.ErrInvalid, D.ReloadAccessPolicy())
+	assert.Equal(allowAll, D.AccessPolicy())
+}
@@ -0,0 +1,383 @@
+package e3x
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+const (
+	cCookieEpoch         = 30 * time.Second
+	cCookieLen           = 16
+	cMaxHandshakeSources = 4096
+)
+
+// HandshakeRateLimit limits the rate (per second) of handshakes from unknown
+// peers for every source IP address (every /64 prefix for IPv6) and for the
+// endpoint as a whole. Up to burst
+// handshakes are accepted at once. A rate of zero is unlimited. The limits are
+// applied before a handshake is decrypted; handshakes of known exchanges are
+// never limited.
+func HandshakeRateLimit(perSource, global float64, burst int) EndpointOption {
+	return func(e *Endpoint) error {
+		g := e.handshakeGuard()
+		g.perSource, g.global, g.burst = perSource, global, burst
+		return nil
+	}
+}
+
+// HandshakeCookies enables stateless handshake cookies. When the global
+// handshake rate limit is reached (or always when always is true) a handshake
+// from an unknown peer is answered with a small retry packet carrying a cookie
+// which is bound to the source address. The peer repeats its handshake with
+// the cookie and is exempt from the global limit. This proves the peer can
+// receive packets at its address before any expensive cryptography is
+// performed.
+func HandshakeCookies(always bool) EndpointOption {
+	return func(e *Endpoint) error {
+		g := e.handshakeGuard()
+		g.cookies, g.always = true, always
+		return nil
+	}
+}
+
+type handshakeGuard struct {
+	mtx       sync.Mutex
+	perSource float64
+	global    float64
+	burst     int
+	cookies   bool
+	always    bool
+	secret    [32]byte
+	sources   map[string]*tokenBucket
+	all       tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (e *Endpoint) handshakeGuard() *handshakeGuard {
+	if e.guard == nil {
+		e.guard = &handshakeGuard{sources: make(map[string]*tokenBucket)}
+		io.ReadFull(rand.Reader, e.guard.secret[:])
+	}
+	return e.guard
+}
+
+// admit decides whether a handshake from an unknown peer at addr may be
+// decrypted. cookie is the cookie sent along with the handshake (if any).
+func (g *handshakeGuard) admit(addr net.Addr, cookie string) error {
+	if g == nil {
+		return nil
+	}
+
+	var (
+		now = time.Now()
+		key = addrKey(addr)
+	)
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if g.perSource > 0 && !g.source(sourceKey(addr), now).take(now, g.perSource, g.burst) {
+		return ErrHandshakeRateLimited
+	}
+
+	if cookie != "" {
+		if !g.verifyCookie(key, cookie, now) {
+			return ErrInvalidCookie
+		}
+		return nil
+	}
+
+	if g.cookies && g.always {
+		return ErrCookieRequired
+	}
+
+	if g.global > 0 && !g.all.take(now, g.global, g.burst) {
+		if g.cookies {
+			return ErrCookieRequired
+		}
+		return ErrHandshakeRateLimited
+	}
+
+	return nil
+}
+
+// source returns the bucket of a source. When there are too many sources the
+// bucket which was used least recently is forgotten. The guard must be locked.
+func (g *handshakeGuard) source(key string, now time.Time) *tokenBucket {
+	b := g.sources[key]
+	if b != nil {
+		return b
+	}
+
+	if len(g.sources) >= cMaxHandshakeSources {
+		var (
+			oldestKey string
+			oldest    *tokenBucket
+		)
+		for k, b := range g.sources {
+			if oldest == nil || b.last.Before(oldest.last) {
+				oldestKey, oldest = k, b
+			}
+		}
+		delete(g.sources, oldestKey)
+	}
+
+	b = &tokenBucket{}
+	g.sources[key] = b
+	return b
+}
+
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	if burst < 1 {
+		burst = 1
+	}
+
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (g *handshakeGuard) makeCookie(key string, epoch int64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(epoch))
+
+	mac := hmac.New(sha256.New, g.secret[:])
+	mac.Write(buf[:])
+	mac.Write([]byte(key))
+	return mac.Sum(nil)[:cCookieLen]
+}
+
+// cookie returns the cookie for addr. It is valid for one to two epochs.
+func (g *handshakeGuard) cookie(addr net.Addr) string {
+	epoch := time.Now().UnixNano() / int64(cCookieEpoch)
+	return encodeCookie(g.makeCookie(addrKey(addr), epoch))
+}
+
+func encodeCookie(p []byte) string {
+	return base64.RawURLEncoding.EncodeToString(p)
+}
+
+func (g *handshakeGuard) verifyCookie(key, cookie string, now time.Time) bool {
+	p, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil || len(p) != cCookieLen {
+		return false
+	}
+
+	epoch := now.UnixNano() / int64(cCookieEpoch)
+	return hmac.Equal(p, g.makeCookie(key, epoch)) ||
+		hmac.Equal(p, g.makeCookie(key, epoch-1))
+}
+
+func addrKey(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.Network() + "/" + addr.String()
+}
+
+// sourceKey returns the key of the rate limit bucket of addr. The port is left
+// out (and IPv6 addresses are reduced to their /64 prefix) so a peer can't get
+// a fresh bucket by changing its source port or address within its network.
+func sourceKey(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addrKey(addr)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return addrKey(addr)
+	}
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 128))
+	}
+	return "ip/" + ip.String()
+}
+
+// sendRetry asks the sender of the handshake identified by token to repeat it
+// with a cookie. The retry is smaller than the handshake.
+func (e *Endpoint) sendRetry(conn net.Conn, token cipherset.Token) {
+	pkt := lob.New(token[:])
+	pkt.Header().SetString("cookie", e.guard.cookie(conn.RemoteAddr()))
+
+	buf, err := lob.Encode(pkt)
+	pkt.Free()
+	if err != nil {
+		return
+	}
+
+	conn.Write(buf.RawBytes())
+	buf.Free()
+}
+
+func isCookiePacket(raw []byte) bool {
+	return len(raw) >= 2 && (raw[0] != 0 || raw[1] > 1)
+}
+
+// decodeCookiePacket decodes a packet with a cookie header. Either token is
+// set (a retry) or handshake is set (a handshake with a cookie).
+func decodeCookiePacket(msg *bufpool.Buffer) (cookie string, token *cipherset.Token, handshake *bufpool.Buffer) {
+	pkt, err := lob.Decode(msg)
+	if err != nil {
+		return "", nil, nil
+	}
+	defer pkt.Free()
+
+	cookie, _ = pkt.Header().GetString("cookie")
+	if cookie == "" {
+		return "", nil, nil
+	}
+
+	body := pkt.Body(nil)
+
+	if len(body) == len(cipherset.ZeroToken) {
+		token = new(cipherset.Token)
+		copy(token[:], body)
+		return cookie, token, nil
+	}
+
+	if len(body) < 3 || body[0] != 0 || body[1] != 1 {
+		return "", nil, nil
+	}
+
+	return cookie, nil, bufpool.New().Set(body)
+}
+
+// receivedCookiePacket handles packets with a cookie header. A retry is passed
+// to the exchange that sent the handshake and nil is returned. A handshake
+// with a cookie is unwrapped and returned along with the cookie.
+func (e *Endpoint) receivedCookiePacket(msg *bufpool.Buffer, conn net.Conn) (*bufpool.Buffer, string, error) {
+	cookie, token, handshake := decodeCookiePacket(msg)
+
+	if token != nil {
+		e.mtx.Lock()
+		x := e.tokens[*token]
+		e.mtx.Unlock()
+
+		if x == nil {
+			return nil, "", ErrUnknownToken
+		}
+		x.receivedRetry(cookie, conn.RemoteAddr())
+		return nil, "", nil
+	}
+
+	if handshake == nil {
+		return nil, "", ErrInvalidPacket
+	}
+
+	return handshake, cookie, nil
+}
+
+// receivedCookiePacket handles a packet with a cookie header which was
+// received over a pipe of the exchange.
+func (x *Exchange) receivedCookiePacket(msg message) {
+	cookie, token, handshake := decodeCookiePacket(msg.Data)
+
+	switch {
+	case token != nil && *token == x.LocalToken():
+		x.receivedRetry(cookie, msg.Pipe.RemoteAddr())
+
+	case token != nil:
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrUnknownToken)
+		x.traceDroppedPacket(msg, nil, ErrUnknownToken.Reason)
+		x.stats.drop(ErrUnknownToken.Reason)
+
+	case handshake != nil:
+		// the cookie is only checked for unknown peers
+		x.receivedHandshake(newMessage(handshake, msg.Pipe))
+		handshake.Free()
+
+	default:
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrInvalidPacket)
+		x.traceDroppedPacket(msg, nil, ErrInvalidPacket.Reason)
+		x.stats.drop(ErrInvalidPacket.Reason)
+	}
+}
+
+// receivedRetry repeats the handshake to addr with cookie.
+func (x *Exchange) receivedRetry(cookie string, addr net.Addr) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	if x.state != ExchangeDialing || addr == nil {
+		return
+	}
+
+	pipe := x.addressBook.PipeToAddr(addr)
+	if pipe == nil {
+		// not a path we sent a handshake to
+		return
+	}
+
+	key := addrKey(addr)
+	if x.cookies[key] == cookie {
+		return
+	}
+	if x.cookies == nil {
+		x.cookies = make(map[string]string)
+	}
+	x.cookies[key] = cookie
+
+	pktData, err := x.generateHandshake(0)
+	if err != nil {
+		return
+	}
+	x.writeHandshake(pipe, pktData)
+}
+
+// writeHandshake sends a handshake over pipe. The handshake is wrapped in a
+// packet with the cookie of the peer when it asked for one. The exchange must
+// be locked.
+func (x *Exchange) writeHandshake(pipe *Pipe, pktData *bufpool.Buffer) error {
+	if cookie := x.cookies[addrKey(pipe.RemoteAddr())]; cookie != "" {
+		pkt := lob.New(pktData.RawBytes())
+		pkt.Header().SetString("cookie", cookie)
+
+		wrapped, err := lob.Encode(pkt)
+		pkt.Free()
+		if err != nil {
+			return err
+		}
+		defer wrapped.Free()
+
+		pktData = wrapped
+	}
+
+	_, err := pipe.Write(pktData)
+	if err == nil {
+		x.addressBook.SentHandshake(pipe)
+		x.stats.sentHandshake()
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package e3x
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestHandshakeGuardAdmit(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		a = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+		b = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 2}
+		e = &Endpoint{}
+	)
+
+	assert.NoError(HandshakeRateLimit(1, 0, 2)(e))
+	g := e.guard
+
+	assert.NoError(g.admit(a, ""))
+	assert.NoError(g.admit(a, ""))
+	assert.Equal(ErrHandshakeRateLimited, g.admit(a, ""))
+	assert.NoError(g.admit(b, ""))
+
+	// changing the source port doesn't help
+	assert.Equal(ErrHandshakeRateLimited, g.admit(&net.UDPAddr{IP: a.IP, Port: 3}, ""))
+
+	// global limit
+	g.perSource, g.global, g.burst = 0, 1, 1
+	assert.NoError(g.admit(a, ""))
+	assert.Equal(ErrHandshakeRateLimited, g.admit(b, ""))
+
+	// cookies are required instead
+	assert.NoError(HandshakeCookies(false)(e))
+	assert.Equal(ErrCookieRequired, g.admit(b, ""))
+	assert.NoError(g.admit(b, g.cookie(b)))
+	assert.Equal(ErrInvalidCookie, g.admit(b, g.cookie(a)))
+	assert.Equal(ErrInvalidCookie, g.admit(b, "garbage"))
+
+	// cookies of the previous epoch are still valid
+	old := g.makeCookie(addrKey(a), time.Now().UnixNano()/int64(cCookieEpoch)-1)
+	assert.True(g.verifyCookie(addrKey(a), encodeCookie(old), time.Now()))
+	older := g.makeCookie(addrKey(a), time.Now().UnixNano()/int64(cCookieEpoch)-2)
+	assert.False(g.verifyCookie(addrKey(a), encodeCookie(older), time.Now()))
+}
+
+func TestHandshakeCookies(t *testing.T) {
+	assert := assert.New(t)
+
+	open := func(options ...EndpointOption) *Endpoint {
+		e, err := Open(append(options, Transport(inproc.Config{}), DisableLog())...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	var (
+		mtx   sync.Mutex
+		drops = map[error]int{}
+	)
+
+	A := open(HandshakeRateLimit(0, 0.001, 1), HandshakeCookies(false), withEndpointHook(EndpointHook{
+		OnDropPacket: func(e *Endpoint, msg []byte, conn net.Conn, reason error) error {
+			mtx.Lock()
+			drops[reason]++
+			mtx.Unlock()
+			return nil
+		},
+	}))
+	defer A.Close()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	dial := func() error {
+		B := open()
+		defer B.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := B.DialContext(ctx, ident)
+		return err
+	}
+
+	// the first handshake fits in the burst
+	assert.NoError(dial())
+
+	// the next peers must send a cookie
+	assert.NoError(dial())
+	assert.NoError(dial())
+
+	mtx.Lock()
+	assert.Equal(2, drops[ErrCookieRequired])
+	mtx.Unlock()
+
+	// without cookies the peers are refused
+	C := open(HandshakeRateLimit(0, 0.001, 1))
+	defer C.Close()
+
+	ident, err = C.LocalIdentity()
+	assert.NoError(err)
+
+	assert.NoError(dial())
+	assert.Equal(context.DeadlineExceeded, dial())
+}
+
+func TestHandshakeGuardSources(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(
+		sourceKey(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}),
+		sourceKey(&net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 2}))
+	assert.NotEqual(
+		sourceKey(&net.UDPAddr{IP: net.ParseIP("2001:db8:0:1::1"), Port: 1}),
+		sourceKey(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}))
+
+	var (
+		g   = &handshakeGuard{sources: make(map[string]*tokenBucket)}
+		now = time.Now()
+	)
+
+	g.source("first", now).last = now
+	for i := 1; i < cMaxHandshakeSources; i++ {
+		g.source(strconv.Itoa(i), now).last = now.Add(time.Second)
+	}
+
+	// only the least recently used bucket is forgotten
+	g.source("new", now)
+	assert.Equal(cMaxHandshakeSources, len(g.sources))
+	assert.Nil(g.sources["first"])
+	assert.NotNil(g.sources["1"])
+}
@@ -0,0 +1,95 @@
+package e3x
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// cEvictMinIdle is how long an exchange must be unused before it can be
+// evicted.
+const cEvictMinIdle = time.Minute
+
+// MaxExchanges limits the number of exchanges of the endpoint. When the limit
+// is reached the least recently used idle exchange is evicted to make room
+// for a new exchange. Only exchanges which were opened by peers and were not
+// used for a minute can be evicted; exchanges created by the endpoint itself
+// (Dial, CreateExchange or ImportSession) are never evicted, so peers can't
+// flush them by handshaking with new hashnames. When no exchange can be
+// evicted new exchanges are refused: Dial fails with ErrTooManyExchanges and
+// handshakes from unknown peers are dropped.
+func MaxExchanges(n int) EndpointOption {
+	return func(e *Endpoint) error {
+		if n > 0 {
+			e.maxExchanges = n
+		}
+		return nil
+	}
+}
+
+// MaxChannels limits the number of open channels of an exchange. Open fails
+// with ErrTooManyChannels and channels opened by the peer are rejected with
+// RejectTooManyChannels when the limit is reached.
+func MaxChannels(n int) ExchangeOption {
+	return func(x *Exchange) error {
+		if n > 0 {
+			x.maxChannels = n
+		}
+		return nil
+	}
+}
+
+// reserveExchange makes room for a new exchange. It evicts the least recently
+// used evictable exchange when the limit is reached and returns false when
+// there is no such exchange. The endpoint must be locked.
+func (e *Endpoint) reserveExchange() bool {
+	if e.maxExchanges <= 0 || len(e.hashnames) < e.maxExchanges {
+		return true
+	}
+
+	var (
+		victim     *Exchange
+		victimUsed int64
+		idleSince  = time.Now().Add(-cEvictMinIdle).UnixNano()
+	)
+
+	for _, x := range e.hashnames {
+		used := atomic.LoadInt64(&x.lastActivity)
+		if x.local || used > idleSince || !x.isEvictable() {
+			continue
+		}
+
+		if victim == nil || used < victimUsed {
+			victim, victimUsed = x, used
+		}
+	}
+
+	if victim == nil {
+		return false
+	}
+
+	e.unregisterExchange(victim)
+	atomic.AddUint64(&e.evictions, 1)
+
+	// closing the exchange calls back into the endpoint
+	go victim.evict()
+	return true
+}
+
+// touch marks the exchange as recently used.
+func (x *Exchange) touch() {
+	atomic.StoreInt64(&x.lastActivity, time.Now().UnixNano())
+}
+
+func (x *Exchange) isEvictable() bool {
+	x.mtx.Lock()
+	idle := x.state == ExchangeIdle
+	x.mtx.Unlock()
+
+	return idle && x.channels.Idle()
+}
+
+func (x *Exchange) evict() {
+	x.log.Println("\x1B[31mEvicted exchange\x1B[0m")
+	x.exchangeHooks.Evicted()
+	x.expire(nil)
+}
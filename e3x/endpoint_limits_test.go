@@ -0,0 +1,248 @@
+package e3x
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestMaxExchanges(t *testing.T) {
+	assert := assert.New(t)
+
+	open := func(options ...EndpointOption) *Endpoint {
+		e, err := Open(append(options, Transport(inproc.Config{}), DisableLog())...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	var (
+		mtx     sync.Mutex
+		evicted []*Exchange
+	)
+
+	A := open(MaxExchanges(2))
+	defer A.Close()
+	A.DefaultExchangeHooks().Register(ExchangeHook{
+		OnEvicted: func(e *Endpoint, x *Exchange) error {
+			mtx.Lock()
+			evicted = append(evicted, x)
+			mtx.Unlock()
+			return nil
+		},
+	})
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	var peers []*Endpoint
+	dial := func(timeout time.Duration) error {
+		e := open()
+		peers = append(peers, e)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_, err := e.DialContext(ctx, ident)
+		return err
+	}
+	defer func() {
+		for _, e := range peers {
+			e.Close()
+		}
+	}()
+
+	// pretend an exchange was not used for long enough to be evicted
+	idle := func(x *Exchange) {
+		atomic.StoreInt64(&x.lastActivity, time.Now().Add(-2*cEvictMinIdle).UnixNano())
+	}
+
+	assert.NoError(dial(time.Second))
+	assert.NoError(dial(time.Second))
+	first := A.GetExchange(peers[0].LocalHashname())
+	if !assert.NotNil(first) {
+		return
+	}
+
+	// keep the second exchange busy
+	second := A.GetExchange(peers[1].LocalHashname())
+	if !assert.NotNil(second) {
+		return
+	}
+	c, err := second.Open("busy", true)
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Close()
+	idle(first)
+	idle(second)
+
+	// the idle exchange is evicted
+	assert.NoError(dial(time.Second))
+	assert.Nil(A.GetExchange(peers[0].LocalHashname()))
+	assert.NotNil(A.GetExchange(peers[2].LocalHashname()))
+	assert.Equal(uint64(1), A.Stats().Evictions)
+
+	state, err := first.WaitState(context.Background(), ExchangeExpired)
+	assert.NoError(err)
+	assert.Equal(ExchangeExpired, state)
+
+	mtx.Lock()
+	assert.Equal([]*Exchange{first}, evicted)
+	mtx.Unlock()
+
+	// recently used exchanges are not evicted
+	third := A.GetExchange(peers[2].LocalHashname())
+	c.Close()
+	_, err = second.WaitState(context.Background(), ExchangeIdle)
+	assert.NoError(err)
+	idle(second)
+
+	assert.NoError(dial(time.Second))
+	assert.Nil(A.GetExchange(peers[1].LocalHashname()))
+	assert.NotNil(A.GetExchange(peers[2].LocalHashname()))
+
+	assert.Equal(context.DeadlineExceeded, dial(200*time.Millisecond))
+	assert.Len(A.GetExchanges(), 2)
+
+	// neither are busy exchanges nor exchanges used by the endpoint itself
+	thirdIdent, err := peers[2].LocalIdentity()
+	assert.NoError(err)
+	x, err := A.CreateExchange(thirdIdent)
+	assert.NoError(err)
+	assert.Equal(third, x)
+
+	fourth := A.GetExchange(peers[3].LocalHashname())
+	if !assert.NotNil(fourth) {
+		return
+	}
+	c, err = fourth.Open("busy", true)
+	if assert.NoError(err) {
+		defer c.Close()
+	}
+
+	idle(third)
+	idle(fourth)
+	assert.Equal(context.DeadlineExceeded, dial(200*time.Millisecond))
+	assert.Len(A.GetExchanges(), 2)
+
+	peerIdent, err := peers[0].LocalIdentity()
+	assert.NoError(err)
+	_, err = A.CreateExchange(peerIdent)
+	assert.Equal(ErrTooManyExchanges, err)
+}
+
+func TestMaxChannels(t *testing.T) {
+	assert := assert.New(t)
+
+	open := func(options ...ExchangeOption) *Endpoint {
+		e, err := Open(
+			Transport(inproc.Config{}),
+			DefaultExchangeOptions(options...),
+			DisableLog())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	A := open(MaxChannels(1))
+	defer A.Close()
+	B := open()
+	defer B.Close()
+
+	l := A.Listen("limited", true)
+	defer l.Close()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	x, err := B.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+
+	write := func() *Channel {
+		c, err := x.Open("limited", true)
+		if !assert.NoError(err) {
+			return nil
+		}
+
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		assert.NoError(c.WritePacket(lob.New(nil)))
+		return c
+	}
+
+	// the first channel is pending
+	c1 := write()
+	if c1 == nil {
+		return
+	}
+	defer c1.Close()
+
+	// the second channel exceeds the channel limit of A
+	c2 := write()
+	if c2 == nil {
+		return
+	}
+	_, err = c2.ReadPacket()
+	if assert.IsType(&RejectedChannelError{}, err) {
+		assert.Equal(RejectTooManyChannels, err.(*RejectedChannelError).Code)
+	}
+
+	// the local limit
+	y := A.GetExchange(B.LocalHashname())
+	if assert.NotNil(y) {
+		_, err = y.Open("limited", true)
+		assert.Equal(ErrTooManyChannels, err)
+	}
+
+	c, err := l.AcceptChannel()
+	if assert.NoError(err) {
+		c.ReadPacket()
+		go c.Close()
+	}
+}
+
+func TestMaxPending(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		assert := assert.New(t)
+
+		l := A.Listen("pending", true, MaxPending(1))
+		defer l.Close()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		var channels []*Channel
+		for i := 0; i < 2; i++ {
+			c, err := B.Open(ident, "pending", true)
+			if !assert.NoError(err) {
+				return
+			}
+			defer c.Close()
+
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+			assert.NoError(c.WritePacket(lob.New(nil)))
+			channels = append(channels, c)
+		}
+
+		_, err = channels[1].ReadPacket()
+		if assert.IsType(&RejectedChannelError{}, err) {
+			assert.Equal(RejectBacklogFull, err.(*RejectedChannelError).Code)
+		}
+
+		c, err := l.AcceptChannel()
+		if assert.NoError(err) {
+			c.ReadPacket()
+			go c.Close()
+		}
+	})
+}
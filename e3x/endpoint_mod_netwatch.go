@@ -2,6 +2,7 @@ package e3x
 
 import (
 	"net"
+	"sync"
 	"time"
 
 	"github.com/telehash/gogotelehash/transports"
@@ -18,6 +19,7 @@ var (
 
 type modNetwatch struct {
 	endpoint  *Endpoint
+	mtx       sync.Mutex
 	timer     *time.Timer
 	addresses []net.Addr
 }
@@ -28,23 +30,23 @@ func (mod *modNetwatch) Init() error {
 
 func (mod *modNetwatch) Start() error {
 	mod.update()
+	mod.mtx.Lock()
 	mod.timer = time.AfterFunc(interval, mod.update)
+	mod.mtx.Unlock()
 	return nil
 }
 
 func (mod *modNetwatch) Stop() error {
+	mod.mtx.Lock()
 	if mod.timer != nil {
 		mod.timer.Stop()
 		mod.timer = nil
 	}
+	mod.mtx.Unlock()
 	return nil
 }
 
 func (mod *modNetwatch) update() {
-	if mod.timer != nil {
-		mod.timer.Reset(interval)
-	}
-
 	var (
 		addrs    = mod.endpoint.transport.Addrs()
 		newAddrs []net.Addr
@@ -52,6 +54,11 @@ func (mod *modNetwatch) update() {
 		update   []net.Addr
 	)
 
+	mod.mtx.Lock()
+	if mod.timer != nil {
+		mod.timer.Reset(interval)
+	}
+
 	// find new addresses
 	for _, x := range addrs {
 		var (
@@ -94,6 +101,7 @@ func (mod *modNetwatch) update() {
 	}
 
 	mod.addresses = update
+	mod.mtx.Unlock()
 
 	if len(newAddrs) > 0 || len(oldAddrs) > 0 {
 		mod.endpoint.Hooks().NetChanged(newAddrs, oldAddrs)
@@ -0,0 +1,146 @@
+package e3x
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// Shutdown gracefully closes the endpoint. New exchanges and channels are
+// refused, every open channel sends an end packet once its buffered packets
+// were written and acknowledged, and the peers are told the exchanges are
+// closing so they can fail over immediately. Then the endpoint is closed like
+// Close does.
+//
+// When ctx is done before all channels were drained the endpoint is closed
+// immediately and ctx.Err() is returned.
+func (e *Endpoint) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&e.draining, 1)
+
+	var (
+		wg   sync.WaitGroup
+		mtx  sync.Mutex
+		errs error
+	)
+
+	for _, x := range e.GetExchanges() {
+		wg.Add(1)
+		go func(x *Exchange) {
+			defer wg.Done()
+
+			if err := x.drain(ctx); err != nil {
+				mtx.Lock()
+				if errs == nil {
+					errs = err
+				}
+				mtx.Unlock()
+			}
+		}(x)
+	}
+	wg.Wait()
+
+	err := e.Close()
+	if errs != nil {
+		return errs
+	}
+	return err
+}
+
+func (e *Endpoint) isDraining() bool {
+	return e != nil && atomic.LoadInt32(&e.draining) == 1
+}
+
+func (x *Exchange) isDraining() bool {
+	return x.endpoint != nil && x.endpoint.isDraining()
+}
+
+// drain drains all the channels of the exchange and then tells the peer the
+// exchange is closing.
+func (x *Exchange) drain(ctx context.Context) error {
+	var (
+		wg   sync.WaitGroup
+		mtx  sync.Mutex
+		errs error
+	)
+
+	for _, c := range x.channels.All() {
+		wg.Add(1)
+		go func(c *Channel) {
+			defer wg.Done()
+
+			if err := c.drain(ctx); err != nil {
+				mtx.Lock()
+				if errs == nil {
+					errs = err
+				}
+				mtx.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if !x.State().IsOpen() {
+		return errs
+	}
+
+	pkt := &lob.Packet{}
+	hdr := pkt.Header()
+	hdr.C, hdr.HasC = 0, true
+	hdr.SetBool("bye", true)
+	x.deliverPacket(pkt, nil)
+	pkt.Free()
+
+	return errs
+}
+
+// receivedBye breaks the exchange; the peer is shutting down.
+func (x *Exchange) receivedBye() {
+	x.log.Println("\x1B[33mPeer is shutting down\x1B[0m")
+	go x.onBreak()
+}
+
+// drain sends an end packet once the buffered packets were written and waits
+// until the peer acknowledged all of them. Channels which were neither read nor
+// written by the application are rejected instead.
+func (c *Channel) drain(ctx context.Context) error {
+	c.mtx.Lock()
+
+	if c.broken {
+		c.mtx.Unlock()
+		return nil
+	}
+
+	if c.serverside && c.iSeq == cBlankSeq && c.oSeq == cBlankSeq {
+		c.mtx.Unlock()
+		if c.Reject(RejectShuttingDown, ErrShuttingDown.Error()) != nil {
+			c.Kill()
+		}
+		return nil
+	}
+
+	defer c.mtx.Unlock()
+
+	err := waitContext(ctx, c.cndWrite, c.blockWrite)
+	if err != nil {
+		return err
+	}
+
+	if !c.broken && !c.deliveredEnd {
+		pkt := &lob.Packet{}
+		hdr := pkt.Header()
+		hdr.End, hdr.HasEnd = true, true
+		if err := c.write(pkt, nil); err != nil {
+			return err
+		}
+	}
+
+	if !c.reliable {
+		return nil
+	}
+
+	return waitContext(ctx, c.cndClose, func() bool {
+		return !c.broken && len(c.writeBuffer) > 0
+	})
+}
@@ -0,0 +1,91 @@
+package e3x
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestEndpointShutdown(t *testing.T) {
+	assert := assert.New(t)
+
+	open := func() *Endpoint {
+		e, err := Open(Transport(inproc.Config{}), DisableLog())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	A := open()
+	B := open()
+	defer B.Close()
+
+	l := A.Listen("drain", true)
+	defer l.Close()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	c, err := B.Open(ident, "drain", true)
+	if !assert.NoError(err) {
+		A.Close()
+		return
+	}
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	assert.NoError(c.WritePacket(lob.New([]byte("hello"))))
+
+	d, err := l.AcceptChannel()
+	if !assert.NoError(err) {
+		A.Close()
+		return
+	}
+	_, err = d.ReadPacket()
+	assert.NoError(err)
+
+	received := make(chan int, 1)
+	go func() {
+		var n int
+		for {
+			_, err := c.ReadPacket()
+			if err != nil {
+				assert.Equal(io.EOF, err)
+				break
+			}
+			n++
+		}
+		received <- n
+	}()
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		assert.NoError(d.WritePacket(lob.New([]byte("data"))))
+	}
+
+	x := B.GetExchange(A.LocalHashname())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(A.Shutdown(ctx))
+
+	// every buffered packet was delivered before the end
+	assert.Equal(n, <-received)
+
+	// the peer learns about the shutdown right away
+	if assert.NotNil(x) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		state, _ := x.WaitState(ctx, ExchangeBroken)
+		assert.Equal(ExchangeBroken, state)
+	}
+
+	_, err = A.CreateExchange(ident)
+	assert.Equal(ErrShuttingDown, err)
+}
@@ -0,0 +1,75 @@
+package e3x
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// EndpointStats is a snapshot of the statistics of all the exchanges of an
+// endpoint. The counters include the exchanges which were closed and the
+// packets which were dropped before they reached an exchange. RTT is always
+// zero.
+type EndpointStats struct {
+	ExchangeStats
+
+	Exchanges int    // number of exchanges
+	Evictions uint64 // idle exchanges evicted to make room for new exchanges
+
+	Listeners map[string]ListenerStats // accept queues by channel type (or pattern)
+}
+
+// Stats returns a snapshot of the endpoint statistics.
+func (e *Endpoint) Stats() EndpointStats {
+	s := EndpointStats{
+		ExchangeStats: ExchangeStats{Drops: make(map[string]uint64)},
+		Evictions:     atomic.LoadUint64(&e.evictions),
+		Listeners:     e.listenerSet.stats(),
+	}
+
+	e.mtx.Lock()
+	s.add(e.closedStats)
+	exchanges := make([]*Exchange, 0, len(e.hashnames))
+	for _, x := range e.hashnames {
+		exchanges = append(exchanges, x)
+	}
+	e.mtx.Unlock()
+
+	s.Drops = e.stats.snapshotDrops(s.Drops)
+
+	for _, x := range exchanges {
+		xs := x.Stats()
+
+		s.Exchanges++
+		s.add(xs)
+		s.Channels += xs.Channels
+		s.ReadBuffered += xs.ReadBuffered
+		s.WriteBuffered += xs.WriteBuffered
+	}
+
+	return s
+}
+
+// add adds the counters of xs to s.
+func (s *ExchangeStats) add(xs ExchangeStats) {
+	s.PacketsSent += xs.PacketsSent
+	s.PacketsReceived += xs.PacketsReceived
+	s.BytesSent += xs.BytesSent
+	s.BytesReceived += xs.BytesReceived
+	s.HandshakesSent += xs.HandshakesSent
+	s.HandshakesReceived += xs.HandshakesReceived
+	s.Rekeys += xs.Rekeys
+	s.Retransmits += xs.Retransmits
+	if len(xs.Drops) > 0 && s.Drops == nil {
+		s.Drops = make(map[string]uint64)
+	}
+	for reason, n := range xs.Drops {
+		s.Drops[reason] += n
+	}
+}
+
+// dropPacket counts a packet dropped by the endpoint and passes it to the
+// OnDropPacket hooks.
+func (e *Endpoint) dropPacket(msg []byte, conn net.Conn, reason error) error {
+	e.stats.drop(dropReason(reason))
+	return e.endpointHooks.DropPacket(msg, conn, reason)
+}
@@ -1,8 +1,8 @@
 package e3x
 
 import (
+	"context"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"math/rand"
 	"net"
@@ -18,8 +18,6 @@ import (
 	"github.com/telehash/gogotelehash/transports"
 )
 
-var ErrInvalidHandshake = errors.New("e3x: invalid handshake")
-
 type BrokenExchangeError hashname.H
 
 func (err BrokenExchangeError) Error() string {
@@ -71,7 +69,10 @@ type Exchange struct {
 	mtx      sync.Mutex
 	cndState *sync.Cond
 
-	state         ExchangeState
+	state          ExchangeState
+	stateChanges   []stateChange
+	notifyingState bool
+
 	lastLocalSeq  uint32
 	lastRemoteSeq uint32
 	nextSeq       uint32
@@ -79,10 +80,39 @@ type Exchange struct {
 	remoteIdent   *Identity
 	csid          uint8
 	cipher        cipherset.State
+	cipherMtx     sync.RWMutex    // guards cipher for LocalToken and RemoteToken
+	nextCipher    cipherset.State // line keys of a rotation in progress
+	prevCipher    cipherset.State // line keys before the last rotation
+	prevExpireAt  time.Time
+	rekeySeq      uint32
+	keysSince     time.Time
+	rekeyBytes    uint64
+	rekeyPackets  uint64
+	rekeyForced   bool
+	rekeyQueued   bool
 	nextChannelID uint32
 	channels      *channelSet
 	addressBook   *addressBook
 	err           error
+	stats         exchangeCounters
+	sched         *scheduler
+	multipath     MultipathMode
+	maxPaths      int
+	probeInterval time.Duration
+	maxChannels   int
+	cookies       map[string]string // handshake cookies by peer address
+	lastActivity  int64             // unix nanoseconds, accessed atomically
+	local         bool              // created by the local endpoint; guarded by the endpoint lock
+
+	rekeyMaxBytes   uint64
+	rekeyMaxPackets uint64
+	rekeyMaxAge     time.Duration
+
+	idleTimeout      time.Duration
+	breakTimeout     time.Duration
+	keepalive        time.Duration
+	handshakeRetry   time.Duration
+	handshakeBackoff float64
 
 	endpoint      endpointI
 	listenerSet   *listenerSet
@@ -90,10 +120,12 @@ type Exchange struct {
 	exchangeHooks ExchangeHooks
 	channelHooks  ChannelHooks
 
-	nextHandshake     int
+	nextHandshake     time.Duration
 	tExpire           *time.Timer
 	tBreak            *time.Timer
 	tDeliverHandshake *time.Timer
+	tProbe            *time.Timer
+	tRekey            *time.Timer
 }
 
 type ExchangeOption func(e *Exchange) error
@@ -101,6 +133,8 @@ type ExchangeOption func(e *Exchange) error
 type endpointI interface {
 	getTID() tracer.ID
 	getTransport() transports.Transport
+	exchangeTokensChanged(x *Exchange, old []cipherset.Token)
+	isDraining() bool
 }
 
 func newExchange(
@@ -113,18 +147,35 @@ func newExchange(
 		localIdent:  localIdent,
 		remoteIdent: remoteIdent,
 		channels:    &channelSet{},
+
+		lastActivity: time.Now().UnixNano(),
+
+		idleTimeout:      cDefaultIdleTimeout,
+		breakTimeout:     cDefaultBreakTimeout,
+		keepalive:        cDefaultKeepalive,
+		handshakeRetry:   cDefaultHandshakeRetry,
+		handshakeBackoff: cDefaultHandshakeBackoff,
 	}
 	x.traceNew()
 
 	x.cndState = sync.NewCond(&x.mtx)
+	x.sched = newScheduler(x.deliverMessage, x.droppedMessage, x.drainedMessages)
+
+	x.setOptions(options...)
 
-	x.tBreak = time.AfterFunc(2*60*time.Second, x.onBreak)
+	x.tBreak = time.AfterFunc(x.breakTimeout, x.onBreak)
 	x.tExpire = time.AfterFunc(60*time.Second, x.onExpire)
-	x.tDeliverHandshake = time.AfterFunc(60*time.Second, x.onDeliverHandshake)
+	x.tDeliverHandshake = time.AfterFunc(x.keepalive, x.onDeliverHandshake)
+	x.tProbe = time.AfterFunc(60*time.Second, x.onProbe)
+	x.tProbe.Stop()
+	if x.probeInterval > 0 {
+		x.tProbe.Reset(x.probeInterval)
+	}
+	x.tRekey = time.AfterFunc(60*time.Second, x.onRekey)
+	x.tRekey.Stop()
 	x.resetExpire()
 	x.rescheduleHandshake()
 
-	x.setOptions(options...)
 	x.channelHooks.Register(ChannelHook{OnClosed: x.unregisterChannel})
 
 	if localIdent == nil {
@@ -321,19 +372,26 @@ func (x *Exchange) traceReceivedPacket(msg message, pkt *lob.Packet) {
 	}
 }
 
-// Dial exchanges the initial handshakes. It will timeout after 2 minutes.
+// Dial exchanges the initial handshakes. It will timeout after the break
+// timeout (2 minutes by default).
 func (x *Exchange) Dial() error {
+	return x.DialContext(context.Background())
+}
+
+// DialContext is like Dial but returns ctx.Err() when ctx is done before the
+// exchange is open. The exchange itself continues dialing.
+func (x *Exchange) DialContext(ctx context.Context) error {
 	x.mtx.Lock()
 	defer x.mtx.Unlock()
 
 	if x.state == 0 {
-		x.state = ExchangeDialing
+		x.setState(ExchangeDialing)
 		x.deliverHandshake()
 		x.rescheduleHandshake()
 	}
 
-	for x.state == ExchangeDialing {
-		x.cndState.Wait()
+	if err := waitContext(ctx, x.cndState, x.isDialing); err != nil {
+		return err
 	}
 
 	if !x.state.IsOpen() {
@@ -343,6 +401,10 @@ func (x *Exchange) Dial() error {
 	return nil
 }
 
+func (x *Exchange) isDialing() bool {
+	return x.state == ExchangeDialing
+}
+
 // RemoteHashname returns the hashname of the remote peer.
 func (x *Exchange) RemoteHashname() hashname.H {
 	hn := x.remoteIdent.Hashname()
@@ -372,18 +434,30 @@ func (x *Exchange) KnownPaths() []net.Addr {
 	return x.addressBook.KnownAddresses()
 }
 
-// KnownPipes returns all the know pipes of the remote endpoint.
+// KnownPipes returns all the know pipes of the remote endpoint. See
+// KnownPipesWithQuality for their measured quality.
 func (x *Exchange) KnownPipes() []*Pipe {
 	return x.addressBook.KnownPipes()
 }
 
+// KnownPipesWithQuality returns all the known pipes of the remote endpoint
+// together with their measured latency, jitter and loss (see ProbePaths). The
+// best path comes first.
+func (x *Exchange) KnownPipesWithQuality() []KnownPipe {
+	return x.addressBook.KnownPipesWithQuality()
+}
+
 func (x *Exchange) dialDialerAddr(addr dialerAddr) (net.Conn, error) {
 	return addr.Dial(x.endpoint.(*Endpoint), x)
 }
 
 func (x *Exchange) received(msg message) {
+	x.touch()
+
 	if msg.IsHandshake {
 		x.receivedHandshake(msg)
+	} else if isCookiePacket(msg.Data.RawBytes()) {
+		x.receivedCookiePacket(msg)
 	} else {
 		x.receivedPacket(msg)
 	}
@@ -413,10 +487,7 @@ func (x *Exchange) deliverHandshake() error {
 	}
 
 	for _, pipe := range x.addressBook.HandshakePipes() {
-		_, err := pipe.Write(pktData)
-		if err == nil {
-			x.addressBook.SentHandshake(pipe)
-		}
+		x.writeHandshake(pipe, pktData)
 	}
 
 	return nil
@@ -424,56 +495,55 @@ func (x *Exchange) deliverHandshake() error {
 
 func (x *Exchange) rescheduleHandshake() {
 	if x.nextHandshake <= 0 {
-		x.nextHandshake = 4
+		x.nextHandshake = x.handshakeRetry
 	} else {
-		x.nextHandshake = x.nextHandshake * 2
+		x.nextHandshake = time.Duration(float64(x.nextHandshake) * x.handshakeBackoff)
 	}
 
-	if x.nextHandshake > 60 {
-		x.nextHandshake = 60
+	if x.nextHandshake > x.keepalive {
+		x.nextHandshake = x.keepalive
 	}
 
-	if n := x.nextHandshake / 3; n > 0 {
-		x.nextHandshake -= rand.Intn(n)
+	if n := int64(x.nextHandshake / 3); n > 0 {
+		x.nextHandshake -= time.Duration(rand.Int63n(n))
 	}
 
-	var d = time.Duration(x.nextHandshake) * time.Second
-	x.tDeliverHandshake.Reset(d)
+	x.tDeliverHandshake.Reset(x.nextHandshake)
 }
 
 func (x *Exchange) receivedPacket(msg message) {
-	const (
-		dropInvalidPacket         = "invalid lob packet"
-		dropExchangeIsNotOpen     = "exchange is not open"
-		dropMissingChannelID      = "missing channel id header"
-		dropMissingChannelType    = "missing channel type header"
-		dropMissingChannelHandler = "missing channel handler"
-	)
+	x.stats.received(msg.Data.Len())
 
 	{
 		x.mtx.Lock()
 		state := x.state
+		if state.IsOpen() {
+			x.countRekey(msg.Data.Len())
+		}
 		x.mtx.Unlock()
 
 		if !state.IsOpen() {
-			x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
-			x.traceDroppedPacket(msg, nil, dropExchangeIsNotOpen)
+			x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrExchangeNotOpen)
+			x.traceDroppedPacket(msg, nil, ErrExchangeNotOpen.Reason)
+			x.stats.drop(ErrExchangeNotOpen.Reason)
 			return // drop
 		}
 	}
 
 	pkt, err := lob.Decode(msg.Data)
 	if err != nil {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
-		x.traceDroppedPacket(msg, nil, dropInvalidPacket)
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrInvalidPacket)
+		x.traceDroppedPacket(msg, nil, ErrInvalidPacket.Reason)
+		x.stats.drop(ErrInvalidPacket.Reason)
 		return // drop
 	}
 
-	pkt2, err := x.cipher.DecryptPacket(pkt)
+	pkt2, err := x.decryptPacket(pkt)
 	pkt.Free()
 	if err != nil {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrInvalidCiphertext)
 		x.traceDroppedPacket(msg, nil, err.Error())
+		x.stats.drop(ErrInvalidCiphertext.Reason)
 		return // drop
 	}
 	pkt2.TID = msg.TID
@@ -487,8 +557,14 @@ func (x *Exchange) receivedPacket(msg message) {
 
 	if !hasC {
 		// drop: missing "c"
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
-		x.traceDroppedPacket(msg, pkt2, dropMissingChannelID)
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrMissingChannelID)
+		x.traceDroppedPacket(msg, pkt2, ErrMissingChannelID.Reason)
+		x.stats.drop(ErrMissingChannelID.Reason)
+		return
+	}
+
+	if cid == 0 {
+		x.receivedControl(msg, pkt2)
 		return
 	}
 
@@ -498,17 +574,22 @@ func (x *Exchange) receivedPacket(msg message) {
 		if c == nil {
 			if !hasType {
 				addPromise.Cancel()
-				x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
-				x.traceDroppedPacket(msg, pkt2, dropMissingChannelType)
+				x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrMissingChannelType)
+				x.traceDroppedPacket(msg, pkt2, ErrMissingChannelType.Reason)
+				x.stats.drop(ErrMissingChannelType.Reason)
 				return // drop (missing typ)
 			}
 
-			listener := x.listenerSet.Get(typ)
-			if listener == nil {
+			listener := x.listenerSet.Get(typ, hasSeq)
+
+			if drop, reject := x.refuseChannel(typ, listener, addPromise.Len()); drop != nil {
 				addPromise.Cancel()
-				x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
-				x.traceDroppedPacket(msg, pkt2, dropMissingChannelHandler)
-				return // drop (no handler)
+				x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, drop)
+				x.traceDroppedPacket(msg, pkt2, dropReason(drop))
+				x.stats.drop(dropReason(drop))
+				x.rejectChannel(cid, reject)
+				pkt2.Free()
+				return // reject
 			}
 
 			c = newChannel(
@@ -517,7 +598,7 @@ func (x *Exchange) receivedPacket(msg message) {
 				hasSeq,
 				true,
 				x,
-				registerExchange(x),
+				append([]ChannelOption{registerExchange(x)}, listener.channelOptions...)...,
 			)
 			c.id = cid
 			addPromise.Add(c)
@@ -537,21 +618,80 @@ func (x *Exchange) receivedPacket(msg message) {
 	c.receivedPacket(pkt2)
 }
 
+// refuseChannel decides whether a channel of type typ opened by the peer is
+// refused, before a Channel is made for it. It returns the reason for dropping
+// the open packet and the reason sent to the peer.
+func (x *Exchange) refuseChannel(typ string, listener *Listener, channels int) (drop, reject error) {
+	if err := x.listenerSet.admitChannel(x.remoteIdent.Hashname(), typ); err != nil {
+		// access denied
+		return err, err
+	}
+
+	if listener == nil {
+		// no handler
+		return ErrMissingChannelHandler, ErrListenerUnknownType
+	}
+
+	if x.maxChannels > 0 && channels >= x.maxChannels {
+		// limit reached
+		return ErrTooManyChannels, ErrTooManyChannels
+	}
+
+	if x.isDraining() {
+		// shutting down
+		return ErrShuttingDown, ErrShuttingDown
+	}
+
+	return nil, nil
+}
+
+// rejectChannel rejects the channel cid opened by the peer (see
+// Channel.Reject) without making a Channel for it.
+func (x *Exchange) rejectChannel(cid uint32, reason error) {
+	pkt := &lob.Packet{}
+	hdr := pkt.Header()
+	hdr.C, hdr.HasC = cid, true
+	hdr.SetString("err", reason.Error())
+	hdr.SetInt("code", int(rejectCodeFor(reason)))
+	x.deliverPacket(pkt, nil)
+	pkt.Free()
+}
+
 func (x *Exchange) deliverPacket(pkt *lob.Packet, p *Pipe) error {
 	x.mtx.Lock()
 	for x.state == ExchangeDialing {
 		x.cndState.Wait()
 	}
 	if !x.state.IsOpen() {
+		x.mtx.Unlock()
 		return BrokenExchangeError(x.remoteIdent.Hashname())
 	}
+	cipher := x.cipher
 	x.mtx.Unlock()
 
-	if p == nil {
-		p = x.addressBook.ActiveConnection()
+	x.touch()
+
+	r := route{pipe: p, dup: pkt.Header().HasSeq, keep: pkt.Header().HasSeq}
+	if p == nil && x.multipath == MultipathOff {
+		r.pipe = x.addressBook.ActiveConnection()
 	}
 
-	pkt2, err := x.cipher.EncryptPacket(pkt)
+	var (
+		cid      = pkt.Header().C
+		priority = PriorityNormal
+		weight   = 1
+	)
+
+	if c := x.channels.Get(cid); c != nil {
+		priority, weight = c.priority, c.weight
+	} else if cid == 0 {
+		priority = PriorityControl
+	}
+
+	// The packet is encrypted before it is queued, so the scheduler may send
+	// it after packets which were encrypted later. This is only safe because
+	// no cipherset enforces a replay window on the receiving side.
+	pkt2, err := cipher.EncryptPacket(pkt)
 	if err != nil {
 		return err
 	}
@@ -562,12 +702,48 @@ func (x *Exchange) deliverPacket(pkt *lob.Packet, p *Pipe) error {
 		return err
 	}
 
-	_, err = p.Write(msg)
-	msg.Free()
+	if x.rekeyMaxBytes > 0 || x.rekeyMaxPackets > 0 {
+		x.mtx.Lock()
+		x.countRekey(msg.Len())
+		x.mtx.Unlock()
+	}
+
+	return x.sched.Send(cid, priority, weight, msg, r)
+}
+
+// deliverMessage is called by the scheduler to write an encrypted packet.
+func (x *Exchange) deliverMessage(msg *bufpool.Buffer, r route) error {
+	if r.pipe == nil {
+		return x.deliverMultipath(msg, r)
+	}
+	return x.writeMessage(msg, r.pipe)
+}
 
+func (x *Exchange) writeMessage(msg *bufpool.Buffer, p *Pipe) error {
+	n := msg.Len()
+	_, err := p.Write(msg)
+	if err == nil {
+		x.stats.sent(n)
+	}
 	return err
 }
 
+// droppedMessage is called by the scheduler when an outgoing packet doesn't
+// fit in the send queue. The DropPacket hooks only see received packets.
+func (x *Exchange) droppedMessage(msg *bufpool.Buffer, r route) {
+	x.stats.drop(ErrSendQueueFull.Reason)
+}
+
+// drainedMessages is called by the scheduler when the send queue has room
+// again. It wakes the writers of reliable channels.
+func (x *Exchange) drainedMessages() {
+	for _, c := range x.channels.All() {
+		c.mtx.Lock()
+		c.cndWrite.Broadcast()
+		c.mtx.Unlock()
+	}
+}
+
 func (x *Exchange) expire(err error) {
 	x.mtx.Lock()
 	if x.state == ExchangeExpired || x.state == ExchangeBroken {
@@ -576,18 +752,19 @@ func (x *Exchange) expire(err error) {
 	}
 
 	if err == nil {
-		x.state = ExchangeExpired
+		x.setState(ExchangeExpired)
 	} else {
 		if x.err != nil {
 			x.err = err
 		}
-		x.state = ExchangeBroken
+		x.setState(ExchangeBroken)
 	}
-	x.cndState.Broadcast()
 
 	x.tBreak.Stop()
 	x.tExpire.Stop()
 	x.tDeliverHandshake.Stop()
+	x.tProbe.Stop()
+	x.tRekey.Stop()
 
 	x.mtx.Unlock()
 
@@ -664,29 +841,27 @@ func (x *Exchange) resetExpire() {
 		x.tExpire.Stop()
 	} else {
 		if x.state.IsOpen() {
-			x.tExpire.Reset(2 * 60 * time.Second)
+			x.tExpire.Reset(x.idleTimeout)
 		}
 	}
 
 	if x.state.IsOpen() {
-		old := x.state
 		if active {
-			x.state = ExchangeActive
+			x.setState(ExchangeActive)
 		} else {
-			x.state = ExchangeIdle
-		}
-		if x.state != old {
-			x.cndState.Broadcast()
+			x.setState(ExchangeIdle)
 		}
 	}
 }
 
 func (x *Exchange) resetBreak() {
-	x.tBreak.Reset(2 * 60 * time.Second)
+	x.tBreak.Reset(x.breakTimeout)
 }
 
 func (x *Exchange) unregisterChannel(_ *Endpoint, _ *Exchange, c *Channel) error {
 	if x.channels.Remove(c.id) {
+		x.collectChannelStats(c)
+
 		x.mtx.Lock()
 		x.resetExpire()
 		x.mtx.Unlock()
@@ -730,7 +905,13 @@ func (x *Exchange) waitDone() {
 }
 
 // Open a channel.
-func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
+func (x *Exchange) Open(typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
+	return x.OpenContext(context.Background(), typ, reliable, options...)
+}
+
+// OpenContext is like Open but returns ctx.Err() when ctx is done before the
+// exchange is open.
+func (x *Exchange) OpenContext(ctx context.Context, typ string, reliable bool, options ...ChannelOption) (*Channel, error) {
 	var (
 		c *Channel
 	)
@@ -741,17 +922,26 @@ func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
 		reliable,
 		false,
 		x,
-		registerExchange(x),
+		append([]ChannelOption{registerExchange(x)}, options...)...,
 	)
 
 	x.mtx.Lock()
-	for x.state == ExchangeDialing {
-		x.cndState.Wait()
+	if err := waitContext(ctx, x.cndState, x.isDialing); err != nil {
+		x.mtx.Unlock()
+		return nil, err
 	}
 	if !x.state.IsOpen() {
 		x.mtx.Unlock()
 		return nil, BrokenExchangeError(x.remoteIdent.Hashname())
 	}
+	if x.maxChannels > 0 && x.channels.Len() >= x.maxChannels {
+		x.mtx.Unlock()
+		return nil, ErrTooManyChannels
+	}
+	if x.isDraining() {
+		x.mtx.Unlock()
+		return nil, ErrShuttingDown
+	}
 
 	c.id = x.getNextChannelID()
 	x.channels.Add(c.id, c)
@@ -765,11 +955,15 @@ func (x *Exchange) Open(typ string, reliable bool) (*Channel, error) {
 
 // LocalToken returns the token identifying the local side of the exchange.
 func (x *Exchange) LocalToken() cipherset.Token {
+	x.cipherMtx.RLock()
+	defer x.cipherMtx.RUnlock()
 	return x.cipher.LocalToken()
 }
 
 // RemoteToken returns the token identifying the remote side of the exchange.
 func (x *Exchange) RemoteToken() cipherset.Token {
+	x.cipherMtx.RLock()
+	defer x.cipherMtx.RUnlock()
 	return x.cipher.RemoteToken()
 }
 
@@ -805,7 +999,7 @@ func (x *Exchange) generateHandshake(seq uint32) (*bufpool.Buffer, error) {
 		seq = x.getNextSeq()
 	}
 
-	body, err := x.cipher.EncryptHandshake(seq, x.localIdent.parts)
+	body, err := x.handshakeCipher().EncryptHandshake(seq, x.localIdent.parts)
 	if err != nil {
 		return nil, err
 	}
@@ -849,33 +1043,38 @@ func (x *Exchange) ApplyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 	x.mtx.Lock()
 	defer x.mtx.Unlock()
 
-	return x.applyHandshake(handshake, pipe)
+	response, err := x.applyHandshake(handshake, pipe)
+	return response, err == nil
 }
 
-func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (response *bufpool.Buffer, ok bool) {
-	var (
-		seq uint32
-		err error
-	)
+// applyHandshake returns the reason for rejecting the handshake (a *DropError)
+// or nil when it was accepted.
+func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (response *bufpool.Buffer, err error) {
+	var seq uint32
 
 	if handshake == nil {
-		return nil, false
+		return nil, ErrInvalidHandshake
 	}
 
 	seq = handshake.At()
 	if seq < x.lastRemoteSeq {
 		// drop; a newer packet has already been processed
-		return nil, false
+		return nil, ErrReplay
 	}
 
 	if handshake.CSID() != x.csid {
 		// drop; wrong csid
-		return nil, false
+		return nil, ErrUnknownCSID
+	}
+
+	var next cipherset.State
+	if x.nextCipher == nil && !x.isLocalSeq(seq) {
+		next = x.responderCipher(handshake)
 	}
 
-	if !x.cipher.ApplyHandshake(handshake) {
+	if next == nil && !x.handshakeCipher().ApplyHandshake(handshake) {
 		// drop; handshake was rejected by the cipherset
-		return nil, false
+		return nil, ErrRejectedHandshake
 	}
 
 	if x.remoteIdent == nil {
@@ -886,7 +1085,7 @@ func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 		)
 		if err != nil {
 			// drop; invalid identity
-			return nil, false
+			return nil, ErrRejectedHandshake
 		}
 		x.remoteIdent = ident
 	}
@@ -895,27 +1094,47 @@ func (x *Exchange) applyHandshake(handshake cipherset.Handshake, pipe *Pipe) (re
 		x.resetBreak()
 		x.addressBook.ReceivedHandshake(pipe)
 
+		if x.nextCipher != nil && seq >= x.rekeySeq {
+			// the peer switched to the new keys
+			x.completeRekey()
+		}
+
 	} else {
-		x.addressBook.AddPipe(pipe)
+		if next != nil {
+			// the peer rotated its line keys; rotate ours too
+			x.nextCipher = next
+			x.completeRekey()
+		}
+
+		x.addressBook.ConfirmPipe(pipe)
 
 		response, err = x.generateHandshake(seq)
 		if err != nil {
 			// drop; invalid identity
-			return nil, false
+			return nil, ErrRejectedHandshake
 		}
 	}
 
 	if x.state == ExchangeDialing || x.state == ExchangeInitialising {
-		x.traceStarted()
+		x.opened()
+	}
 
-		x.state = ExchangeIdle
-		x.resetExpire()
-		x.cndState.Broadcast()
+	return response, nil
+}
 
-		go x.exchangeHooks.Opened()
-	}
+// opened moves a new exchange to the idle state once its line keys are
+// established. The exchange must be locked.
+func (x *Exchange) opened() {
+	x.traceStarted()
+
+	x.setState(ExchangeIdle)
+	x.resetExpire()
+
+	x.keysSince = time.Now()
+	x.rescheduleRekey(x.keysSince)
+	x.cookies = nil
 
-	return response, true
+	go x.exchangeHooks.Opened()
 }
 
 func (x *Exchange) receivedHandshake(msg message) bool {
@@ -931,44 +1150,52 @@ func (x *Exchange) receivedHandshake(msg message) bool {
 	)
 
 	if !msg.IsHandshake {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrInvalidHandshake)
 		x.traceDroppedHandshake(msg, nil, "invalid packet")
+		x.stats.drop(ErrInvalidHandshake.Reason)
 		return false
 	}
 
 	pkt, err = lob.Decode(msg.Data)
 	if err != nil {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, err)
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrInvalidHandshake)
 		x.traceDroppedHandshake(msg, nil, err.Error())
+		x.stats.drop(ErrInvalidHandshake.Reason)
 		return false
 	}
 
 	hdr := pkt.Header()
 	if !hdr.IsBinary() && len(hdr.Bytes) != 1 {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrInvalidHandshake)
 		x.traceDroppedHandshake(msg, nil, "invalid header")
+		x.stats.drop(ErrInvalidHandshake.Reason)
 		return false
 	}
 	csid = uint8(hdr.Bytes[0])
 
 	handshake, err = cipherset.DecryptHandshake(csid, x.localIdent.keys[csid], pkt.Body(buf[:0]))
 	if err != nil {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, err)
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrInvalidHandshake)
 		x.traceDroppedHandshake(msg, nil, err.Error())
+		x.stats.drop(ErrInvalidHandshake.Reason)
 		return false
 	}
 
-	resp, ok := x.applyHandshake(handshake, msg.Pipe)
-	if !ok {
-		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, nil)
+	resp, err := x.applyHandshake(handshake, msg.Pipe)
+	if err != nil {
+		x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, err)
 		x.traceDroppedHandshake(msg, handshake, "failed to apply")
+		x.stats.drop(dropReason(err))
 		return false
 	}
 
 	x.lastRemoteSeq = handshake.At()
+	x.stats.receivedHandshake()
 
 	if resp != nil {
-		msg.Pipe.Write(resp)
+		if _, err := msg.Pipe.Write(resp); err == nil {
+			x.stats.sentHandshake()
+		}
 	}
 
 	x.traceReceivedHandshake(msg, handshake)
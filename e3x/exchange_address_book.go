@@ -22,6 +22,7 @@ type addressBook struct {
 	active      *addressBookEntry
 	known       []*addressBookEntry
 	unsupported []string
+	probeSeq    uint32
 }
 
 const (
@@ -37,9 +38,17 @@ type addressBookEntry struct {
 	ExpireAt            time.Time
 	Reachable           bool
 	IsBackup            bool
+	Confirmed           bool // received a handshake over this path
 
-	latency time.Duration
-	ewma    time.Duration
+	latency   time.Duration
+	ewma      time.Duration
+	jitter    time.Duration
+	loss      float64 // ewma of unanswered handshakes and probes
+	wrr       float64 // current weight for multipath striping
+	hasSample bool
+
+	probeID     uint32
+	probeSentAt time.Time
 }
 
 func newAddressBook(log *logs.Logger) *addressBook {
@@ -58,6 +67,18 @@ func (book *addressBook) ActiveConnection() *Pipe {
 	return e.Pipe
 }
 
+// ActiveLatency returns the latency of the active path.
+func (book *addressBook) ActiveLatency() time.Duration {
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	if book.active == nil {
+		return 0
+	}
+
+	return book.active.latency
+}
+
 func (book *addressBook) KnownAddresses() []net.Addr {
 	book.mtx.RLock()
 	defer book.mtx.RUnlock()
@@ -117,12 +138,14 @@ func (book *addressBook) NextHandshakeEpoch() {
 			if !e.ReceivedHandshakeAt.IsZero() {
 				// successful handshake: update latency
 				e.AddLatencySample(e.ReceivedHandshakeAt.Sub(e.SendHandshakeAt))
+				e.AddLossSample(false)
 				e.ExpireAt = e.ReceivedHandshakeAt.Add(2 * time.Minute)
 				e.Reachable = true
 				book.log.Printf("\x1B[34mUpdated path\x1B[0m %s (latency=\x1B[33m%s\x1B[0m, emwa=\x1B[33m%s\x1B[0m)", e, e.latency, e.ewma)
 
 			} else {
 				// no response
+				e.AddLossSample(true)
 				if e.ExpireAt.Before(now) {
 					// reached deadline
 					e.Reachable = false
@@ -144,6 +167,17 @@ func (book *addressBook) NextHandshakeEpoch() {
 
 	}
 
+	book.reorder()
+}
+
+// reorder sorts the known paths and selects the active and backup paths.
+// The address book must be locked.
+func (book *addressBook) reorder() {
+	if len(book.known) == 0 {
+		book.active = nil
+		return
+	}
+
 	// sort by state and latency
 	sort.Sort(sortedAddressBookEntries(book.known))
 
@@ -196,6 +230,21 @@ func (book *addressBook) AddPipe(p *Pipe) {
 	book.mtx.Lock()
 	defer book.mtx.Unlock()
 
+	book.addPipe(p)
+}
+
+// ConfirmPipe adds p (when it is unknown) and marks it as confirmed. It must
+// be called when the peer sent a handshake over p.
+func (book *addressBook) ConfirmPipe(p *Pipe) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	if e := book.addPipe(p); e != nil {
+		e.Confirmed = true
+	}
+}
+
+func (book *addressBook) addPipe(p *Pipe) *addressBookEntry {
 	var (
 		now = time.Now()
 		idx = book.indexOfPipe(p)
@@ -203,7 +252,7 @@ func (book *addressBook) AddPipe(p *Pipe) {
 	)
 
 	if idx >= 0 {
-		return
+		return book.known[idx]
 	}
 
 	e = &addressBookEntry{Address: p.raddr, Pipe: p}
@@ -220,6 +269,8 @@ func (book *addressBook) AddPipe(p *Pipe) {
 		book.active = e
 		book.log.Printf("\x1B[32mChanged path\x1B[0m from %s to %s", (*addressBookEntry)(nil), book.active)
 	}
+
+	return e
 }
 
 func (book *addressBook) SentHandshake(pipe *Pipe) {
@@ -248,11 +299,13 @@ func (book *addressBook) ReceivedHandshake(p *Pipe) {
 	)
 
 	if idx < 0 {
-		book.AddPipe(p)
+		e = book.addPipe(p)
+		e.Confirmed = true
 		return
 	}
 
 	e = book.known[idx]
+	e.Confirmed = true
 	if !e.SendHandshakeAt.IsZero() {
 		e.ReceivedHandshakeAt = time.Now()
 	}
@@ -284,10 +337,28 @@ func (a *addressBookEntry) String() string {
 }
 
 func (a *addressBookEntry) AddLatencySample(d time.Duration) {
+	if a.hasSample {
+		// RFC 3550 interarrival jitter
+		delta := d - a.latency
+		if delta < 0 {
+			delta = -delta
+		}
+		a.jitter += (delta - a.jitter) / 16
+	}
+
+	a.hasSample = true
 	a.latency = d
 	a.ewma = time.Duration(ewma_α*float64(d) + (1.0-ewma_α)*float64(a.ewma))
 }
 
+func (a *addressBookEntry) AddLossSample(lost bool) {
+	var x float64
+	if lost {
+		x = 1
+	}
+	a.loss = ewma_α*x + (1.0-ewma_α)*a.loss
+}
+
 func (a *addressBookEntry) InitSamples() {
 	a.latency = 125 * time.Millisecond
 	a.ewma = 125 * time.Millisecond
@@ -306,5 +377,10 @@ func (s sortedAddressBookEntries) Less(i, j int) bool {
 		return false
 	}
 
+	if li, lj := s[i].loss < cMaxMultipathLoss, s[j].loss < cMaxMultipathLoss; li != lj {
+		// prefer paths which don't lose most packets
+		return li
+	}
+
 	return s[i].ewma < s[j].ewma
 }
@@ -0,0 +1,170 @@
+package e3x
+
+import (
+	"sort"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+// MultipathMode selects how an exchange uses the paths (pipes) it knows of.
+type MultipathMode int
+
+const (
+	// MultipathOff sends all packets over the active path (the default).
+	MultipathOff MultipathMode = iota
+
+	// MultipathStripe spreads packets over the healthiest paths, weighted by
+	// their latency and loss.
+	MultipathStripe
+
+	// MultipathDuplicate sends the packets of reliable channels over all the
+	// healthiest paths. Other packets are sent over the best path.
+	MultipathDuplicate
+)
+
+const (
+	cMaxMultipathLoss = 0.5 // paths losing more handshakes are not used
+	cMaxMultipathSkew = 4   // striped paths are at most this much slower than the best path
+)
+
+// route tells the scheduler where to send a packet.
+type route struct {
+	pipe *Pipe // explicit destination; when nil a path is selected on send
+	dup  bool  // the packet may be duplicated over multiple paths
+	keep bool  // the packet is queued even when the send queue is full
+}
+
+// Multipath enables multipath mode on an exchange. At most maxPaths paths are
+// used at the same time (0 means no limit). In any multipath mode a packet
+// which can't be written to a path is written to the next healthy path, so
+// channels don't notice a failing path.
+func Multipath(mode MultipathMode, maxPaths int) ExchangeOption {
+	return func(x *Exchange) error {
+		if maxPaths < 0 {
+			maxPaths = 0
+		}
+		x.multipath = mode
+		x.maxPaths = maxPaths
+		return nil
+	}
+}
+
+func (x *Exchange) deliverMultipath(msg *bufpool.Buffer, r route) error {
+	pipes := x.addressBook.MultipathPipes(x.maxPaths, x.multipath == MultipathStripe)
+	if len(pipes) == 0 {
+		p := x.addressBook.ActiveConnection()
+		if p == nil {
+			return UnreachableEndpointError(x.remoteIdent.Hashname())
+		}
+		pipes = []*Pipe{p}
+	}
+
+	if x.multipath == MultipathDuplicate && r.dup {
+		var (
+			n    = msg.Len()
+			sent bool
+			err  error
+		)
+
+		for _, p := range pipes {
+			if _, werr := p.Write(msg); werr != nil {
+				err = werr
+			} else {
+				sent = true
+			}
+		}
+
+		if !sent {
+			return err
+		}
+		x.stats.sent(n)
+		return nil
+	}
+
+	// fail over to the next path
+	var err error
+	for _, p := range pipes {
+		if err = x.writeMessage(msg, p); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// MultipathPipes returns up to n (0 means all) healthy pipes in order of
+// preference. When stripe is true the first pipe is selected by smooth
+// weighted round robin; the others follow as fallbacks.
+func (book *addressBook) MultipathPipes(n int, stripe bool) []*Pipe {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	var healthy []*addressBookEntry
+	for _, e := range book.known {
+		if e.IsHealthy() {
+			healthy = append(healthy, e)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	sort.Sort(sortedAddressBookEntries(healthy))
+
+	if n > 0 && len(healthy) > n {
+		healthy = healthy[:n]
+	}
+
+	if stripe && len(healthy) > 1 {
+		// much slower paths would reorder the stream
+		best := healthy[0].ewma
+		for i, e := range healthy {
+			if e.ewma > cMaxMultipathSkew*best {
+				healthy = healthy[:i]
+				break
+			}
+		}
+
+		var (
+			total float64
+			sel   int
+		)
+
+		for i, e := range healthy {
+			w := e.Weight()
+			e.wrr += w
+			total += w
+			if e.wrr > healthy[sel].wrr {
+				sel = i
+			}
+		}
+
+		healthy[sel].wrr -= total
+
+		// move the selected pipe to the front
+		e := healthy[sel]
+		copy(healthy[1:sel+1], healthy[:sel])
+		healthy[0] = e
+	}
+
+	pipes := make([]*Pipe, len(healthy))
+	for i, e := range healthy {
+		pipes[i] = e.Pipe
+	}
+	return pipes
+}
+
+// IsHealthy returns true when the path may be used in multipath mode.
+func (a *addressBookEntry) IsHealthy() bool {
+	return a.Reachable && a.Confirmed && a.loss < cMaxMultipathLoss
+}
+
+// Weight returns the share of striped packets sent over the path.
+func (a *addressBookEntry) Weight() float64 {
+	ewma := a.ewma
+	if ewma < time.Microsecond {
+		ewma = time.Microsecond
+	}
+	return (1 - a.loss) / float64(ewma)
+}
@@ -0,0 +1,137 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/inproc"
+	"github.com/telehash/gogotelehash/transports/mux"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestMultipathPipes(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		book = newAddressBook(nil)
+		fast = &Pipe{}
+		slow = &Pipe{}
+		lazy = &Pipe{}
+		lost = &Pipe{}
+		new_ = &Pipe{}
+	)
+
+	entry := func(p *Pipe, ewma time.Duration, loss float64, confirmed bool) *addressBookEntry {
+		return &addressBookEntry{Pipe: p, Reachable: true, Confirmed: confirmed, ewma: ewma, loss: loss}
+	}
+
+	book.known = []*addressBookEntry{
+		entry(new_, 1*time.Millisecond, 0, false),
+		entry(lazy, 100*time.Millisecond, 0, true),
+		entry(slow, 20*time.Millisecond, 0, true),
+		entry(lost, 10*time.Millisecond, 0.7, true),
+		entry(fast, 10*time.Millisecond, 0, true),
+	}
+
+	// unconfirmed and lossy paths are never used
+	assert.Equal([]*Pipe{fast, slow, lazy}, book.MultipathPipes(0, false))
+	assert.Equal([]*Pipe{fast, slow}, book.MultipathPipes(2, false))
+
+	// striping is weighted by latency and skips much slower paths
+	counts := map[*Pipe]int{}
+	for i := 0; i < 300; i++ {
+		pipes := book.MultipathPipes(0, true)
+		if assert.Equal(2, len(pipes)) {
+			counts[pipes[0]]++
+		}
+	}
+	assert.Equal(200, counts[fast])
+	assert.Equal(100, counts[slow])
+	assert.Equal(0, counts[lazy])
+}
+
+func TestMultipathExchange(t *testing.T) {
+	for _, mode := range []MultipathMode{MultipathStripe, MultipathDuplicate} {
+		testMultipathExchange(t, mode)
+	}
+}
+
+func testMultipathExchange(t *testing.T, mode MultipathMode) {
+	const n = 100
+
+	open := func() *Endpoint {
+		e, err := Open(
+			Transport(mux.Config{inproc.Config{}, udp.Config{Network: "udp4"}}),
+			DefaultExchangeOptions(Multipath(mode, 0)),
+			DisableLog())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	A := open()
+	defer A.Close()
+	B := open()
+	defer B.Close()
+
+	var (
+		assert = assert.New(t)
+		done   = make(chan struct{})
+	)
+
+	go func() {
+		defer close(done)
+
+		c, err := A.Listen("multipath", true).AcceptChannel()
+		if !assert.NoError(err) || !assert.NotNil(c) {
+			return
+		}
+		defer c.Close()
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		for i := 0; i < n; i++ {
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+			id, _ := pkt.Header().GetInt("id")
+			assert.Equal(i, id)
+			pkt.Free()
+
+			if i == 0 {
+				assert.NoError(c.WritePacket(lob.New(nil)))
+			}
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	c, err := B.Open(ident, "multipath", true)
+	if !assert.NoError(err) {
+		return
+	}
+
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	for i := 0; i < n; i++ {
+		pkt := lob.New(nil)
+		pkt.Header().SetInt("id", i)
+		if !assert.NoError(c.WritePacket(pkt)) {
+			break
+		}
+
+		if i == 0 {
+			_, err = c.ReadPacket()
+			assert.NoError(err)
+		}
+	}
+
+	assert.NoError(c.Close())
+	<-done
+}
@@ -30,6 +30,7 @@ type message struct {
 type pipeDelegate interface {
 	received(msg message)
 	dialDialerAddr(dialerAddr) (net.Conn, error)
+	pathQuality(p *Pipe) PathQuality
 }
 
 type dialerAddr interface {
@@ -0,0 +1,218 @@
+package e3x
+
+import (
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+const cDefaultProbeInterval = 5 * time.Second
+
+// ProbePaths makes the exchange send a probe over every known path at the
+// given interval (the default interval is 5 seconds). Probing is disabled by
+// default as peers which don't answer probes would appear to lose every
+// probe.
+func ProbePaths(interval time.Duration) ExchangeOption {
+	return func(x *Exchange) error {
+		if interval <= 0 {
+			interval = cDefaultProbeInterval
+		}
+		x.probeInterval = interval
+		return nil
+	}
+}
+
+// PathQuality describes the measured quality of a path. It is updated by the
+// handshakes and by the probes an exchange sends over every known path (see
+// ProbePaths).
+type PathQuality struct {
+	Reachable       bool
+	Confirmed       bool          // the peer sent packets over the path
+	Latency         time.Duration // last measured round trip time
+	SmoothedLatency time.Duration
+	Jitter          time.Duration // variation of the round trip time
+	Loss            float64       // fraction of unanswered probes and handshakes
+}
+
+// KnownPipe is a known pipe of an exchange and its measured quality.
+type KnownPipe struct {
+	Pipe    *Pipe
+	Quality PathQuality
+}
+
+// Quality returns the measured quality of the path.
+func (p *Pipe) Quality() PathQuality {
+	if p.delegate == nil {
+		return PathQuality{}
+	}
+	return p.delegate.pathQuality(p)
+}
+
+func (x *Exchange) pathQuality(p *Pipe) PathQuality {
+	return x.addressBook.PathQuality(p)
+}
+
+func (x *Exchange) onProbe() {
+	x.mtx.Lock()
+	if x.state == ExchangeExpired || x.state == ExchangeBroken {
+		x.mtx.Unlock()
+		return
+	}
+	open := x.state.IsOpen()
+	x.tProbe.Reset(x.probeInterval)
+	x.mtx.Unlock()
+
+	if open {
+		x.deliverProbes()
+	}
+}
+
+// deliverProbes sends a probe over every known path. The peer answers with a
+// pong over the same path.
+func (x *Exchange) deliverProbes() {
+	for _, probe := range x.addressBook.NextProbeRound() {
+		pkt := &lob.Packet{}
+		hdr := pkt.Header()
+		hdr.C, hdr.HasC = 0, true
+		hdr.SetUint32("probe", probe.id)
+
+		if err := x.deliverPacket(pkt, probe.pipe); err != nil {
+			x.log.Printf("\x1B[31mFailed to probe\x1B[0m %s error=%s", probe.pipe.RemoteAddr(), err)
+		}
+		pkt.Free()
+	}
+}
+
+// receivedControl handles packets for channel 0, which is never allocated to
+// a channel.
+func (x *Exchange) receivedControl(msg message, pkt *lob.Packet) {
+	defer pkt.Free()
+
+	hdr := pkt.Header()
+
+	if id, ok := hdr.GetUint32("probe"); ok {
+		resp := &lob.Packet{}
+		hdr := resp.Header()
+		hdr.C, hdr.HasC = 0, true
+		hdr.SetUint32("pong", id)
+		x.deliverPacket(resp, msg.Pipe)
+		resp.Free()
+		return
+	}
+
+	if id, ok := hdr.GetUint32("pong"); ok {
+		x.addressBook.ReceivedProbe(msg.Pipe, id)
+		return
+	}
+
+	if bye, _ := hdr.GetBool("bye"); bye {
+		x.receivedBye()
+		return
+	}
+
+	x.exchangeHooks.DropPacket(msg.Data.Get(nil), msg.Pipe, ErrUnknownControlPacket)
+	x.traceDroppedPacket(msg, pkt, ErrUnknownControlPacket.Reason)
+	x.stats.drop(ErrUnknownControlPacket.Reason)
+}
+
+type pathProbe struct {
+	pipe *Pipe
+	id   uint32
+}
+
+// NextProbeRound starts a new round of probes and returns a probe for every
+// known path. Probes of the previous round which were not answered are
+// counted as lost.
+func (book *addressBook) NextProbeRound() []pathProbe {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	var (
+		now    = time.Now()
+		probes = make([]pathProbe, 0, len(book.known))
+	)
+
+	for _, e := range book.known {
+		if !e.probeSentAt.IsZero() {
+			e.AddLossSample(true)
+		}
+
+		book.probeSeq++
+		e.probeID = book.probeSeq
+		e.probeSentAt = now
+		probes = append(probes, pathProbe{e.Pipe, e.probeID})
+	}
+
+	book.reorder()
+
+	return probes
+}
+
+// ReceivedProbe records the answer to a probe sent over p.
+func (book *addressBook) ReceivedProbe(p *Pipe, id uint32) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	idx := book.indexOfPipe(p)
+	if idx < 0 {
+		// the pong may arrive over a new pipe; match it by address.
+		if addr := p.RemoteAddr(); addr != nil {
+			idx = book.indexOf(addr)
+		}
+	}
+	if idx < 0 {
+		return
+	}
+
+	e := book.known[idx]
+	if e.probeSentAt.IsZero() || e.probeID != id {
+		// late or unknown
+		return
+	}
+
+	e.AddLatencySample(time.Since(e.probeSentAt))
+	e.AddLossSample(false)
+	e.probeSentAt = time.Time{}
+	e.Reachable = true
+	e.Confirmed = true
+	e.ExpireAt = time.Now().Add(2 * time.Minute)
+}
+
+// PathQuality returns the measured quality of p.
+func (book *addressBook) PathQuality(p *Pipe) PathQuality {
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	idx := book.indexOfPipe(p)
+	if idx < 0 {
+		return PathQuality{}
+	}
+
+	return book.known[idx].quality()
+}
+
+// KnownPipesWithQuality returns every known pipe with its measured quality.
+func (book *addressBook) KnownPipesWithQuality() []KnownPipe {
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	s := make([]KnownPipe, len(book.known))
+	for i, e := range book.known {
+		s[i] = KnownPipe{Pipe: e.Pipe, Quality: e.quality()}
+	}
+
+	return s
+}
+
+// quality returns the measured quality of the path. The address book must be
+// locked.
+func (e *addressBookEntry) quality() PathQuality {
+	return PathQuality{
+		Reachable:       e.Reachable,
+		Confirmed:       e.Confirmed,
+		Latency:         e.latency,
+		SmoothedLatency: e.ewma,
+		Jitter:          e.jitter,
+		Loss:            e.loss,
+	}
+}
@@ -0,0 +1,115 @@
+package e3x
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestAddressBookProbes(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		book = newAddressBook(nil)
+		good = &Pipe{raddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}}
+		bad  = &Pipe{raddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}}
+	)
+
+	book.known = []*addressBookEntry{
+		{Address: bad.raddr, Pipe: bad, Reachable: true, ewma: time.Millisecond},
+		{Address: good.raddr, Pipe: good, Reachable: true, ewma: 10 * time.Millisecond},
+	}
+
+	for i := 0; i < 5; i++ {
+		for _, probe := range book.NextProbeRound() {
+			if probe.pipe == good {
+				book.ReceivedProbe(probe.pipe, probe.id)
+				book.ReceivedProbe(probe.pipe, probe.id) // duplicate
+			}
+		}
+	}
+
+	// answered probes are matched by address
+	book.NextProbeRound()
+	book.known[0].probeSentAt = time.Now().Add(-50 * time.Millisecond)
+	book.ReceivedProbe(&Pipe{raddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}}, book.known[0].probeID)
+
+	q := good.Quality()
+	assert.Equal(PathQuality{}, q, "pipe without delegate")
+
+	q = book.PathQuality(good)
+	assert.True(q.Reachable)
+	assert.True(q.Confirmed)
+	assert.Equal(0.0, q.Loss)
+	assert.True(q.Latency >= 50*time.Millisecond)
+	assert.True(q.Jitter > 0)
+
+	q = book.PathQuality(bad)
+	assert.False(q.Confirmed)
+	assert.True(q.Loss > cMaxMultipathLoss)
+
+	// the lossy path is no longer preferred
+	assert.Equal(good, book.ActiveConnection())
+
+	// only probe answers are matched by address
+	other := &Pipe{raddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}}
+	assert.Equal(PathQuality{}, book.PathQuality(other))
+	assert.Equal(good, book.KnownPipesWithQuality()[0].Pipe)
+	assert.Equal(book.PathQuality(good), book.KnownPipesWithQuality()[0].Quality)
+}
+
+func TestExchangeProbes(t *testing.T) {
+	assert := assert.New(t)
+
+	open := func(options ...EndpointOption) *Endpoint {
+		e, err := Open(append(options, Transport(inproc.Config{}), DisableLog())...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	A := open()
+	defer A.Close()
+	B := open(DefaultExchangeOptions(ProbePaths(10 * time.Millisecond)))
+	defer B.Close()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	x, err := B.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+
+	// probing is disabled by default
+	if y := A.GetExchange(B.LocalHashname()); assert.NotNil(y) {
+		assert.Equal(time.Duration(0), y.probeInterval)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var (
+			measured int
+			pipes    = x.KnownPipesWithQuality()
+		)
+		for _, p := range pipes {
+			if q := p.Quality; q.Confirmed && q.Latency > 0 {
+				assert.Equal(q, p.Pipe.Quality())
+				measured++
+			}
+		}
+
+		if measured > 0 && measured == len(pipes) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d paths were measured", measured, len(pipes))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
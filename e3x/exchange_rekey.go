@@ -0,0 +1,279 @@
+package e3x
+
+import (
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// cRekeyGrace is how long the previous line keys are kept after a rotation;
+// packets which were in flight during the rotation can still be decrypted.
+const cRekeyGrace = 30 * time.Second
+
+// RekeyAfter makes the exchange rotate its line keys after maxBytes bytes or
+// maxPackets packets were sent and received with them, or after they were in
+// use for maxAge. A zero value disables the respective limit. The rotation is
+// a fresh handshake with a new line key, to which the peer responds with a new
+// line key of its own. Both sides keep their previous keys for a grace period;
+// open channels are not affected.
+func RekeyAfter(maxBytes, maxPackets uint64, maxAge time.Duration) ExchangeOption {
+	return func(x *Exchange) error {
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		x.rekeyMaxBytes = maxBytes
+		x.rekeyMaxPackets = maxPackets
+		x.rekeyMaxAge = maxAge
+		return nil
+	}
+}
+
+// Rekey starts a rotation of the line keys. The rotation completes in the
+// background once the peer responded to the new handshake.
+func (x *Exchange) Rekey() error {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	if !x.state.IsOpen() {
+		return BrokenExchangeError(x.remoteIdent.Hashname())
+	}
+
+	x.rekeyForced = true
+	x.tRekey.Reset(0)
+	return nil
+}
+
+// countRekey counts a packet sent or received with the current line keys.
+// The exchange must be locked.
+func (x *Exchange) countRekey(n int) {
+	x.rekeyBytes += uint64(n)
+	x.rekeyPackets++
+
+	if x.rekeyQueued || x.nextCipher != nil {
+		return
+	}
+
+	if (x.rekeyMaxBytes > 0 && x.rekeyBytes >= x.rekeyMaxBytes) ||
+		(x.rekeyMaxPackets > 0 && x.rekeyPackets >= x.rekeyMaxPackets) {
+		x.rekeyQueued = true
+		x.tRekey.Reset(0)
+	}
+}
+
+func (x *Exchange) isRekeyDue(now time.Time) bool {
+	switch {
+	case x.rekeyForced, x.rekeyQueued:
+		return true
+	case x.rekeyMaxAge > 0 && !x.keysSince.IsZero() && now.Sub(x.keysSince) >= x.rekeyMaxAge:
+		return true
+	default:
+		return false
+	}
+}
+
+func (x *Exchange) onRekey() {
+	x.mtx.Lock()
+
+	var (
+		now = time.Now()
+		old = x.tokensLocked()
+	)
+
+	if x.prevCipher != nil && !now.Before(x.prevExpireAt) {
+		x.prevCipher = nil
+	}
+
+	if x.state.IsOpen() && x.prevCipher == nil && x.nextCipher == nil && x.isRekeyDue(now) {
+		x.rekeyForced = false
+		x.rekeyQueued = false
+
+		if sent, err := x.startRekey(); err != nil {
+			x.log.Printf("\x1B[31mFailed to rekey\x1B[0m error=%s", err)
+		} else if sent {
+			x.log.Printf("\x1B[32mRekeying\x1B[0m")
+		}
+	}
+
+	x.rescheduleRekey(now)
+	x.mtx.Unlock()
+
+	if x.endpoint != nil {
+		x.endpoint.exchangeTokensChanged(x, old)
+	}
+}
+
+// rescheduleRekey arms the rekey timer for the next rotation or for the
+// expiry of the previous line keys. The exchange must be locked.
+func (x *Exchange) rescheduleRekey(now time.Time) {
+	var at time.Time
+
+	switch {
+	case x.prevCipher != nil:
+		// no new rotation until the previous keys expired
+		at = x.prevExpireAt
+	case x.nextCipher != nil:
+		// the rotation is in progress
+	case x.rekeyForced || x.rekeyQueued:
+		at = now
+	case x.rekeyMaxAge > 0 && !x.keysSince.IsZero():
+		at = x.keysSince.Add(x.rekeyMaxAge)
+	}
+
+	if at.IsZero() {
+		x.tRekey.Stop()
+		return
+	}
+
+	d := at.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	x.tRekey.Reset(d)
+}
+
+// startRekey prepares a new cipher state with a fresh line key and sends a
+// handshake for it. The exchange must be locked.
+func (x *Exchange) startRekey() (bool, error) {
+	if x.remoteIdent == nil {
+		return false, nil
+	}
+
+	next, err := cipherset.NewState(x.csid, x.localIdent.keys[x.csid])
+	if err != nil {
+		return false, err
+	}
+
+	err = next.SetRemoteKey(x.remoteIdent.keys[x.csid])
+	if err != nil {
+		return false, err
+	}
+
+	x.nextCipher = next
+	x.rekeySeq = x.lastLocalSeq + 1
+
+	// the peer switches to the new keys as soon as it sees the handshake;
+	// retry soon when the handshake is lost.
+	x.nextHandshake = 0
+	x.rescheduleHandshake()
+	return true, x.deliverHandshake()
+}
+
+// responderCipher returns a new cipher state with a fresh line key when the
+// request handshake carries a new line key of the peer (the peer started a
+// rotation). It returns nil when the handshake must be applied to the current
+// cipher state. The exchange must be locked.
+func (x *Exchange) responderCipher(handshake cipherset.Handshake) cipherset.State {
+	if !x.state.IsOpen() || x.remoteIdent == nil || !x.cipher.CanDecryptPacket() {
+		return nil
+	}
+
+	next, err := cipherset.NewState(x.csid, x.localIdent.keys[x.csid])
+	if err != nil {
+		return nil
+	}
+
+	err = next.SetRemoteKey(x.remoteIdent.keys[x.csid])
+	if err != nil {
+		return nil
+	}
+
+	if !next.ApplyHandshake(handshake) || next.RemoteToken() == x.cipher.RemoteToken() {
+		return nil
+	}
+
+	return next
+}
+
+// completeRekey switches to the new line keys. The previous keys are kept for
+// cRekeyGrace. The exchange must be locked.
+func (x *Exchange) completeRekey() {
+	if x.nextCipher == nil {
+		return
+	}
+
+	now := time.Now()
+
+	x.cipherMtx.Lock()
+	x.prevCipher = x.cipher
+	x.cipher = x.nextCipher
+	x.nextCipher = nil
+	x.cipherMtx.Unlock()
+
+	x.prevExpireAt = now.Add(cRekeyGrace)
+	x.keysSince = now
+	x.rekeyBytes = 0
+	x.rekeyPackets = 0
+	x.stats.rekeyed()
+
+	x.log.Printf("\x1B[32mRotated line keys\x1B[0m")
+	x.rescheduleRekey(now)
+}
+
+// handshakeCipher returns the cipher state which must be used for new
+// handshakes. The exchange must be locked.
+func (x *Exchange) handshakeCipher() cipherset.State {
+	if x.nextCipher != nil {
+		return x.nextCipher
+	}
+	return x.cipher
+}
+
+// decryptPacket decrypts pkt with the current line keys, or with the previous
+// or next line keys during a rotation. When the peer already switched to the
+// next keys the rotation is completed.
+func (x *Exchange) decryptPacket(pkt *lob.Packet) (*lob.Packet, error) {
+	x.mtx.Lock()
+	var (
+		cipher = x.cipher
+		prev   = x.prevCipher
+		next   = x.nextCipher
+	)
+	x.mtx.Unlock()
+
+	pkt2, err := cipher.DecryptPacket(pkt)
+	if err == nil {
+		return pkt2, nil
+	}
+
+	if prev != nil {
+		if pkt2, err2 := prev.DecryptPacket(pkt); err2 == nil {
+			return pkt2, nil
+		}
+	}
+
+	if next != nil && next.CanDecryptPacket() {
+		if pkt2, err2 := next.DecryptPacket(pkt); err2 == nil {
+			x.mtx.Lock()
+			if x.nextCipher == next {
+				x.completeRekey()
+			}
+			x.mtx.Unlock()
+			return pkt2, nil
+		}
+	}
+
+	return nil, err
+}
+
+// tokens returns the tokens of all the line keys which are in use.
+func (x *Exchange) tokens() []cipherset.Token {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+	return x.tokensLocked()
+}
+
+func (x *Exchange) tokensLocked() []cipherset.Token {
+	if x.cipher == nil {
+		return nil
+	}
+
+	tokens := []cipherset.Token{x.cipher.LocalToken(), x.cipher.RemoteToken()}
+	if x.nextCipher != nil {
+		tokens = append(tokens, x.nextCipher.LocalToken())
+	}
+	if x.prevCipher != nil {
+		tokens = append(tokens, x.prevCipher.LocalToken(), x.prevCipher.RemoteToken())
+	}
+	return tokens
+}
@@ -0,0 +1,142 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/inproc"
+	"github.com/telehash/gogotelehash/transports/mux"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestRekey(t *testing.T) {
+	const n = 30
+
+	open := func(options ...ExchangeOption) *Endpoint {
+		e, err := Open(
+			Transport(mux.Config{inproc.Config{}, udp.Config{Network: "udp4"}}),
+			DefaultExchangeOptions(options...),
+			DisableLog())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	A := open()
+	defer A.Close()
+	B := open(RekeyAfter(0, 20, 0))
+	defer B.Close()
+
+	var (
+		assert = assert.New(t)
+		done   = make(chan struct{})
+	)
+
+	waitForRekeys := func(x *Exchange, n uint64) {
+		deadline := time.Now().Add(2 * time.Second)
+		for x.Stats().Rekeys < n && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.Equal(n, x.Stats().Rekeys)
+	}
+
+	go func() {
+		defer close(done)
+
+		c, err := A.Listen("rekey", true).AcceptChannel()
+		if !assert.NoError(err) || !assert.NotNil(c) {
+			return
+		}
+		defer c.Close()
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		for i := 0; i < 2*n; i++ {
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+			pkt.Free()
+
+			if !assert.NoError(c.WritePacket(lob.New(nil))) {
+				return
+			}
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	x, err := B.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+	token := x.LocalToken()
+
+	bIdent, err := B.LocalIdentity()
+	assert.NoError(err)
+	y := A.GetExchange(bIdent.Hashname())
+	if !assert.NotNil(y) {
+		return
+	}
+	peerToken := y.LocalToken()
+
+	c, err := x.Open("rekey", true)
+	if !assert.NoError(err) {
+		return
+	}
+
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	pingPong := func() {
+		for i := 0; i < n; i++ {
+			if !assert.NoError(c.WritePacket(lob.New(nil))) {
+				return
+			}
+
+			pkt, err := c.ReadPacket()
+			if !assert.NoError(err) {
+				return
+			}
+			pkt.Free()
+		}
+	}
+
+	pingPong()
+	waitForRekeys(x, 1)
+	assert.NotEqual(token, x.LocalToken())
+
+	// the responder rotated its line key too
+	waitForRekeys(y, 1)
+	assert.NotEqual(peerToken, y.LocalToken())
+	y.mtx.Lock()
+	assert.NotNil(y.prevCipher)
+	y.mtx.Unlock()
+
+	// the channel survived the rotation
+	pingPong()
+	assert.NoError(c.Close())
+	<-done
+
+	// no packets were undecryptable during the rotation
+	assert.Equal(uint64(0), x.Stats().Drops[ErrInvalidCiphertext.Reason])
+	assert.Equal(uint64(0), y.Stats().Drops[ErrInvalidCiphertext.Reason])
+
+	// a forced rotation waits for the previous keys to expire
+	x.mtx.Lock()
+	x.prevExpireAt = time.Now()
+	x.mtx.Unlock()
+	assert.NoError(x.Rekey())
+	waitForRekeys(x, 2)
+	assert.Equal(uint64(2), B.Stats().Rekeys)
+
+	c, err = x.Open("rekey", false)
+	if assert.NoError(err) {
+		assert.NoError(c.WritePacket(lob.New(nil)))
+		c.Close()
+	}
+}
@@ -0,0 +1,236 @@
+package e3x
+
+import (
+	"sync"
+
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+// Priority is the send priority class of a channel. Packets of channels in a
+// higher class are always sent before packets of channels in a lower class.
+type Priority int
+
+const (
+	PriorityBulk        Priority = -1
+	PriorityNormal      Priority = 0 // the default
+	PriorityInteractive Priority = 1
+	PriorityControl     Priority = 2
+
+	numPriorityClasses = int(PriorityControl-PriorityBulk) + 1
+)
+
+const (
+	cMaxSendWeight     = 1000
+	cSchedQuantum      = 1500 // bytes per weight per round
+	cMaxSchedQueueSize = 4096 // packets queued per exchange
+	cMaxSchedBatch     = 64   // packets sent by a writer before handing off
+)
+
+// SendPriority sets the priority class and weight of a channel. Channels in
+// the same class share the path in proportion to their weight (weighted fair
+// queuing). The default is PriorityNormal with a weight of 1.
+func SendPriority(class Priority, weight int) ChannelOption {
+	return func(c *Channel) error {
+		if class < PriorityBulk {
+			class = PriorityBulk
+		}
+		if class > PriorityControl {
+			class = PriorityControl
+		}
+		if weight < 1 {
+			weight = 1
+		}
+		if weight > cMaxSendWeight {
+			weight = cMaxSendWeight
+		}
+		c.priority = class
+		c.weight = weight
+		return nil
+	}
+}
+
+// scheduler orders the outgoing packets of an exchange. The writer that finds
+// the scheduler idle sends all queued packets (including the packets queued
+// by concurrent writers) in priority order. Within a priority class flows
+// are served with deficit round robin.
+//
+// Reliable channels wait for room in the queue (see hasRoom) before they
+// write, so their packets are never dropped. The queue may exceed its size by
+// the packets of writers which found room concurrently.
+type scheduler struct {
+	deliver func(msg *bufpool.Buffer, r route) error
+	dropped func(msg *bufpool.Buffer, r route)
+	drained func()
+
+	mtx     sync.Mutex
+	sending bool
+	full    bool // a writer found the queue full
+	queued  int
+	classes [numPriorityClasses]schedClass
+}
+
+type schedClass struct {
+	active []*schedFlow
+	flows  map[uint32]*schedFlow
+}
+
+type schedFlow struct {
+	id      uint32
+	weight  int
+	deficit int
+	turn    bool
+	queue   []schedItem
+}
+
+type schedItem struct {
+	msg   *bufpool.Buffer
+	route route
+}
+
+func newScheduler(deliver func(msg *bufpool.Buffer, r route) error, dropped func(msg *bufpool.Buffer, r route), drained func()) *scheduler {
+	return &scheduler{deliver: deliver, dropped: dropped, drained: drained}
+}
+
+// hasRoom returns false when the queue is full. The drained func is called
+// (on a new goroutine) once the queue has room again.
+func (s *scheduler) hasRoom() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.queued < cMaxSchedQueueSize {
+		return true
+	}
+
+	s.full = true
+	return false
+}
+
+// Send queues msg for flow id. When the scheduler is idle the caller sends
+// the queued packets and the error for msg is returned. Otherwise Send returns
+// immediately. When the queue is full msg is dropped and ErrSendQueueFull is
+// returned, unless r.keep is set. Send takes ownership of msg.
+func (s *scheduler) Send(id uint32, class Priority, weight int, msg *bufpool.Buffer, r route) error {
+	s.mtx.Lock()
+
+	if s.queued >= cMaxSchedQueueSize && !r.keep {
+		s.full = true
+		s.mtx.Unlock()
+		if s.dropped != nil {
+			s.dropped(msg, r)
+		}
+		msg.Free()
+		return ErrSendQueueFull
+	}
+
+	s.push(id, class, weight, schedItem{msg, r})
+
+	if s.sending {
+		s.mtx.Unlock()
+		return nil
+	}
+
+	s.sending = true
+	return s.run(msg)
+}
+
+// run sends queued packets. The scheduler must be locked and will be unlocked.
+func (s *scheduler) run(own *bufpool.Buffer) error {
+	var ownErr error
+
+	for i := 0; ; i++ {
+		if i == cMaxSchedBatch {
+			// hand off to a new goroutine; don't starve the writer.
+			s.mtx.Unlock()
+			go s.continueRun()
+			return ownErr
+		}
+
+		item, ok := s.pop()
+		if !ok {
+			s.sending = false
+			s.mtx.Unlock()
+			return ownErr
+		}
+		if s.full && s.queued < cMaxSchedQueueSize {
+			s.full = false
+			if s.drained != nil {
+				// the waiting writers may hold the locks of their channels
+				go s.drained()
+			}
+		}
+		s.mtx.Unlock()
+
+		err := s.deliver(item.msg, item.route)
+		if item.msg == own {
+			ownErr, own = err, nil
+		}
+		item.msg.Free()
+
+		s.mtx.Lock()
+	}
+}
+
+func (s *scheduler) continueRun() {
+	s.mtx.Lock()
+	s.run(nil)
+}
+
+func (s *scheduler) push(id uint32, class Priority, weight int, item schedItem) {
+	c := &s.classes[int(PriorityControl-class)]
+
+	f := c.flows[id]
+	if f == nil {
+		if c.flows == nil {
+			c.flows = make(map[uint32]*schedFlow)
+		}
+		f = &schedFlow{id: id}
+		c.flows[id] = f
+		c.active = append(c.active, f)
+	}
+
+	f.weight = weight
+	f.queue = append(f.queue, item)
+	s.queued++
+}
+
+func (s *scheduler) pop() (schedItem, bool) {
+	for i := range s.classes {
+		if item, ok := s.classes[i].pop(); ok {
+			s.queued--
+			return item, true
+		}
+	}
+	return schedItem{}, false
+}
+
+func (c *schedClass) pop() (schedItem, bool) {
+	for len(c.active) > 0 {
+		f := c.active[0]
+
+		if !f.turn {
+			f.turn = true
+			f.deficit += f.weight * cSchedQuantum
+		}
+
+		item := f.queue[0]
+		if n := item.msg.Len(); n <= f.deficit {
+			f.deficit -= n
+			f.queue[0] = schedItem{}
+			f.queue = f.queue[1:]
+
+			if len(f.queue) == 0 {
+				// flow is idle
+				c.active = c.active[1:]
+				delete(c.flows, f.id)
+			}
+
+			return item, true
+		}
+
+		// end of turn
+		f.turn = false
+		c.active = append(c.active[1:], f)
+	}
+
+	return schedItem{}, false
+}
@@ -0,0 +1,237 @@
+package e3x
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/internal/util/bufpool"
+)
+
+func TestSchedulerPriority(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		gate = make(chan struct{})
+		sent []byte
+		wg   sync.WaitGroup
+	)
+
+	s := newScheduler(func(msg *bufpool.Buffer, r route) error {
+		if len(sent) == 0 {
+			<-gate
+		}
+		sent = append(sent, msg.RawBytes()[0])
+		return nil
+	}, nil, nil)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.Send(1, PriorityNormal, 1, bufpool.New().Set([]byte{'n'}), route{})
+	}()
+
+	// wait until the first writer is sending
+	for {
+		s.mtx.Lock()
+		sending := s.sending
+		s.mtx.Unlock()
+		if sending {
+			break
+		}
+	}
+
+	s.Send(2, PriorityBulk, 1, bufpool.New().Set([]byte{'b'}), route{})
+	s.Send(3, PriorityNormal, 1, bufpool.New().Set([]byte{'n'}), route{})
+	s.Send(4, PriorityInteractive, 1, bufpool.New().Set([]byte{'i'}), route{})
+	s.Send(5, PriorityControl, 1, bufpool.New().Set([]byte{'c'}), route{})
+
+	close(gate)
+	wg.Wait()
+
+	assert.Equal("ncinb", string(sent))
+}
+
+func TestSchedulerWeights(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		s = &scheduler{}
+		a = bytes.Repeat([]byte{'a'}, cSchedQuantum)
+		b = bytes.Repeat([]byte{'b'}, cSchedQuantum)
+	)
+
+	for i := 0; i < 40; i++ {
+		s.push(1, PriorityNormal, 1, schedItem{msg: bufpool.New().Set(a)})
+		s.push(2, PriorityNormal, 3, schedItem{msg: bufpool.New().Set(b)})
+	}
+
+	counts := map[byte]int{}
+	for i := 0; i < 40; i++ {
+		item, ok := s.pop()
+		if !assert.True(ok) {
+			return
+		}
+		counts[item.msg.RawBytes()[0]]++
+		item.msg.Free()
+	}
+
+	assert.Equal(10, counts['a'])
+	assert.Equal(30, counts['b'])
+	assert.Equal(40, s.queued)
+}
+
+func TestSendPriorityOption(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &Channel{}
+	SendPriority(PriorityControl+5, 0)(c)
+	assert.Equal(PriorityControl, c.priority)
+	assert.Equal(1, c.weight)
+
+	SendPriority(PriorityBulk-5, cMaxSendWeight+1)(c)
+	assert.Equal(PriorityBulk, c.priority)
+	assert.Equal(cMaxSendWeight, c.weight)
+}
+
+func TestSchedulerQueueFull(t *testing.T) {
+	assert := assert.New(t)
+
+	var dropped int
+
+	s := newScheduler(nil, func(msg *bufpool.Buffer, r route) {
+		dropped++
+	}, nil)
+
+	// pretend another writer is sending; every packet stays queued
+	s.sending = true
+
+	for i := 0; i < cMaxSchedQueueSize; i++ {
+		assert.NoError(s.Send(1, PriorityNormal, 1, bufpool.New().Set([]byte{'a'}), route{}))
+	}
+
+	assert.Equal(ErrSendQueueFull, s.Send(1, PriorityNormal, 1, bufpool.New().Set([]byte{'b'}), route{}))
+	assert.Equal(1, dropped)
+	assert.False(s.hasRoom())
+
+	// packets of reliable channels are never dropped
+	assert.NoError(s.Send(1, PriorityNormal, 1, bufpool.New().Set([]byte{'c'}), route{keep: true}))
+	assert.Equal(1, dropped)
+	assert.Equal(cMaxSchedQueueSize+1, s.queued)
+}
+
+func TestSchedulerDrained(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		drained = make(chan struct{}, 1)
+		s       = newScheduler(func(msg *bufpool.Buffer, r route) error {
+			return nil
+		}, nil, func() {
+			drained <- struct{}{}
+		})
+	)
+
+	// pretend another writer is sending; every packet stays queued
+	s.sending = true
+	for i := 0; i < cMaxSchedQueueSize; i++ {
+		assert.NoError(s.Send(1, PriorityNormal, 1, bufpool.New().Set([]byte{'a'}), route{}))
+	}
+	assert.False(s.hasRoom())
+
+	s.mtx.Lock()
+	s.run(nil)
+
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("drained was not called")
+	}
+	assert.True(s.hasRoom())
+}
+
+func TestReliableWriteWaitsForRoom(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("ping", true).AcceptChannel()
+			if !assert.NoError(err) || !assert.NotNil(c) {
+				return
+			}
+			defer c.Close()
+
+			c.SetDeadline(time.Now().Add(10 * time.Second))
+
+			for _, expected := range []string{"a", "c"} {
+				pkt, err := c.ReadPacket()
+				if !assert.NoError(err) || !assert.NotNil(pkt) {
+					return
+				}
+				assert.Equal(expected, string(pkt.Body(nil)))
+
+				if expected == "a" {
+					assert.NoError(c.WritePacket(lob.New([]byte("b"))))
+				}
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "ping", true)
+		if !assert.NoError(err) {
+			return
+		}
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		assert.NoError(c.WritePacket(lob.New([]byte("a"))))
+		pkt, err := c.ReadPacket()
+		if assert.NoError(err) && assert.NotNil(pkt) {
+			assert.Equal("b", string(pkt.Body(nil)))
+		}
+
+		// fill the send queue while pretending another writer is sending
+		s := c.Exchange().sched
+		s.mtx.Lock()
+		s.sending = true
+		s.mtx.Unlock()
+		for i := 0; i < cMaxSchedQueueSize; i++ {
+			s.Send(0, PriorityBulk, 1, bufpool.New().Set([]byte{0}), route{})
+		}
+
+		written := make(chan error, 1)
+		go func() {
+			written <- c.WritePacket(lob.New([]byte("c")))
+		}()
+
+		select {
+		case err := <-written:
+			t.Fatalf("write did not wait for room: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		// drain the queue
+		s.mtx.Lock()
+		s.run(nil)
+
+		select {
+		case err := <-written:
+			assert.NoError(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("write was not resumed")
+		}
+
+		assert.NoError(c.Close())
+		<-done
+	})
+}
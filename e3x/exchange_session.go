@@ -0,0 +1,276 @@
+package e3x
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"time"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/transports"
+)
+
+// ExchangeSession is the exported state of an exchange. It can be stored
+// (it marshals to JSON) and imported by a restarted endpoint with the same
+// keys to re-establish the exchange without losing the known paths.
+//
+// When CipherState is set the session contains the private line keys of the
+// exchange. Anyone holding it can decrypt the packets of the line, so it must
+// be protected like the endpoint keys and discarded once it was imported.
+type ExchangeSession struct {
+	Identity      *Identity     `json:"identity"`
+	Paths         []SessionPath `json:"paths,omitempty"`
+	CSID          uint8         `json:"csid"`
+	LastLocalSeq  uint32        `json:"local_seq"`
+	LastRemoteSeq uint32        `json:"remote_seq"`
+	NextChannelID uint32        `json:"next_channel_id"`
+	CipherState   []byte        `json:"cipher_state,omitempty"`
+}
+
+// SessionPath is a known path of an exported exchange.
+type SessionPath struct {
+	Addr      net.Addr
+	Latency   time.Duration
+	Loss      float64
+	Reachable bool
+}
+
+type jsonSessionPath struct {
+	Addr      json.RawMessage `json:"addr"`
+	Latency   time.Duration   `json:"latency"`
+	Loss      float64         `json:"loss"`
+	Reachable bool            `json:"reachable"`
+}
+
+func (p SessionPath) MarshalJSON() ([]byte, error) {
+	addr, err := transports.EncodeAddr(p.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(jsonSessionPath{addr, p.Latency, p.Loss, p.Reachable})
+}
+
+func (p *SessionPath) UnmarshalJSON(data []byte) error {
+	var j jsonSessionPath
+
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+
+	addr, err := transports.DecodeAddr(j.Addr)
+	if err != nil {
+		return err
+	}
+
+	*p = SessionPath{addr, j.Latency, j.Loss, j.Reachable}
+	return nil
+}
+
+// ExportSession returns the state of the exchange. When withKeys is true and
+// the cipherset supports it the line keys are exported as well; otherwise the
+// importing endpoint must perform a new handshake.
+func (x *Exchange) ExportSession(withKeys bool) (*ExchangeSession, error) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	if x.remoteIdent == nil {
+		return nil, os.ErrInvalid
+	}
+	if x.state.IsClosed() {
+		return nil, BrokenExchangeError(x.remoteIdent.Hashname())
+	}
+
+	s := &ExchangeSession{
+		Identity:      x.remoteIdent.withPaths(nil),
+		Paths:         x.addressBook.SessionPaths(),
+		CSID:          x.csid,
+		LastLocalSeq:  x.lastLocalSeq,
+		LastRemoteSeq: x.lastRemoteSeq,
+		NextChannelID: x.nextChannelID,
+	}
+
+	// keys of a rotation in progress are not exported; the importing endpoint
+	// performs a new handshake instead.
+	if m, ok := x.cipher.(cipherset.StateMarshaler); ok && withKeys && x.state.IsOpen() && x.nextCipher == nil {
+		p, err := m.MarshalState()
+		if err != nil {
+			return nil, err
+		}
+		s.CipherState = p
+	}
+
+	return s, nil
+}
+
+// restoreSession applies s to a new exchange and reports whether the line
+// keys were restored. The exchange must be locked.
+func (x *Exchange) restoreSession(s *ExchangeSession) bool {
+	for _, path := range s.Paths {
+		x.addressBook.RestorePath(newPipe(x.endpoint.getTransport(), nil, path.Addr, x), path)
+	}
+
+	x.lastLocalSeq = s.LastLocalSeq
+	x.lastRemoteSeq = s.LastRemoteSeq
+	x.nextChannelID = s.NextChannelID
+
+	if s.CipherState == nil || s.CSID != x.csid {
+		return false
+	}
+
+	m, ok := x.cipher.(cipherset.StateMarshaler)
+	if !ok {
+		return false
+	}
+
+	x.cipherMtx.Lock()
+	err := m.UnmarshalState(s.CipherState)
+	x.cipherMtx.Unlock()
+	if err != nil {
+		x.log.Printf("\x1B[31mFailed to restore line keys\x1B[0m %s", err)
+		return false
+	}
+
+	return x.cipher.CanEncryptPacket() && x.cipher.CanDecryptPacket()
+}
+
+// SessionPaths returns the known paths in order of preference.
+func (book *addressBook) SessionPaths() []SessionPath {
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	s := make([]SessionPath, 0, len(book.known))
+	for _, e := range book.known {
+		if _, ok := e.Address.(transports.AddrMarshaler); !ok {
+			// the path can't be stored
+			continue
+		}
+
+		s = append(s, SessionPath{
+			Addr:      e.Address,
+			Latency:   e.ewma,
+			Loss:      e.loss,
+			Reachable: e.Reachable,
+		})
+	}
+
+	return s
+}
+
+// RestorePath adds p with the quality of an exported path. Every restored
+// path is tried again; paths which were unreachable are tried last.
+func (book *addressBook) RestorePath(p *Pipe, path SessionPath) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	e := book.addPipe(p)
+	if path.Latency > 0 {
+		e.latency = path.Latency
+		e.ewma = path.Latency
+	}
+	e.loss = path.Loss
+	if !path.Reachable {
+		e.loss = 1
+	}
+
+	book.reorder()
+}
+
+// ExportSessions returns the sessions of all open exchanges (see
+// Exchange.ExportSession).
+func (e *Endpoint) ExportSessions(withKeys bool) ([]*ExchangeSession, error) {
+	var sessions []*ExchangeSession
+
+	for _, x := range e.GetExchanges() {
+		if !x.State().IsOpen() {
+			continue
+		}
+
+		s, err := x.ExportSession(withKeys)
+		if err != nil {
+			if _, ok := err.(BrokenExchangeError); ok {
+				continue // closed in the meantime
+			}
+			return nil, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	return sessions, nil
+}
+
+// ImportSession re-establishes the exchange described by s. When the session
+// contains line keys the exchange is open immediately and the peer only
+// receives a keepalive handshake. Otherwise the exchange dials the peer over
+// the exported paths. An existing exchange with the same peer is returned as
+// is.
+func (e *Endpoint) ImportSession(s *ExchangeSession) (*Exchange, error) {
+	if s == nil || s.Identity == nil {
+		return nil, os.ErrInvalid
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	hn := s.Identity.Hashname()
+	if x := e.hashnames[hn]; x != nil {
+		return x, nil
+	}
+
+	if e.isDraining() {
+		return nil, ErrShuttingDown
+	}
+
+	if !e.reserveExchange() {
+		return nil, ErrTooManyExchanges
+	}
+
+	localIdent, err := e.LocalIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	x, err := newExchange(localIdent, s.Identity.withPaths(nil), nil, e.log, e.newExchangeOptions()...)
+	if err != nil {
+		return nil, err
+	}
+
+	x.mtx.Lock()
+	restored := x.restoreSession(s)
+	x.mtx.Unlock()
+
+	x.local = true
+	e.hashnames[hn] = x
+	e.updateTokens(x, nil)
+
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	if restored {
+		x.log.Println("\x1B[32mRestored line keys\x1B[0m")
+		x.opened()
+	} else {
+		x.setState(ExchangeDialing)
+	}
+	x.deliverHandshake()
+	x.rescheduleHandshake()
+
+	return x, nil
+}
+
+// ImportSessions imports all sessions (see Endpoint.ImportSession). It returns
+// the first error but imports the remaining sessions regardless.
+func (e *Endpoint) ImportSessions(sessions []*ExchangeSession) error {
+	var firstErr error
+
+	for _, s := range sessions {
+		_, err := e.ImportSession(s)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,145 @@
+package e3x
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/e3x/cipherset"
+	"github.com/telehash/gogotelehash/internal/lob"
+	"github.com/telehash/gogotelehash/transports/udp"
+)
+
+func TestExchangeSessionResumption(t *testing.T) {
+	assert := assert.New(t)
+
+	keys, err := cipherset.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the restarted endpoint binds to the same port
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	laddr := l.LocalAddr().String()
+	l.Close()
+
+	open := func(options ...EndpointOption) *Endpoint {
+		e, err := Open(append(options, DisableLog())...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	A := open(Transport(udp.Config{Network: "udp4", Addr: "127.0.0.1:0"}))
+	defer A.Close()
+
+	go func() {
+		l := A.Listen("resume", true)
+		defer l.Close()
+
+		for {
+			c, err := l.AcceptChannel()
+			if err != nil {
+				return
+			}
+
+			pkt, err := c.ReadPacket()
+			if err == nil {
+				c.WritePacket(pkt)
+			}
+			c.Close()
+		}
+	}()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	ping := func(x *Exchange) {
+		c, err := x.Open("resume", true)
+		if !assert.NoError(err) {
+			return
+		}
+		defer c.Close()
+
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+		pkt, err := c.ReadPacket()
+		if assert.NoError(err) {
+			assert.Equal([]byte("ping"), pkt.Body(nil))
+		}
+	}
+
+	B := open(Keys(keys), Transport(udp.Config{Network: "udp4", Addr: laddr}))
+
+	x, err := B.Dial(ident)
+	if !assert.NoError(err) {
+		B.Close()
+		return
+	}
+	ping(x)
+
+	sessions, err := B.ExportSessions(true)
+	assert.NoError(err)
+	data, err := json.Marshal(sessions)
+	assert.NoError(err)
+	token := x.LocalToken()
+	B.Close()
+
+	peer := A.GetExchange(B.LocalHashname())
+	peerToken := peer.LocalToken()
+
+	// restart with the line keys
+	sessions = nil
+	assert.NoError(json.Unmarshal(data, &sessions))
+	if assert.Len(sessions, 1) {
+		assert.Equal(ident.Hashname(), sessions[0].Identity.Hashname())
+		assert.NotEmpty(sessions[0].Paths)
+		assert.NotEmpty(sessions[0].CipherState)
+	}
+
+	B = open(Keys(keys), Transport(udp.Config{Network: "udp4", Addr: laddr}))
+	assert.NoError(B.ImportSessions(sessions))
+
+	y := B.GetExchange(ident.Hashname())
+	if assert.NotNil(y) {
+		assert.True(y.State().IsOpen())
+		assert.Equal(token, y.LocalToken())
+		ping(y)
+	}
+
+	// the peer kept its exchange and its line keys
+	assert.True(peer == A.GetExchange(B.LocalHashname()))
+	assert.Equal(peerToken, peer.LocalToken())
+
+	sessions, err = B.ExportSessions(false)
+	assert.NoError(err)
+	B.Close()
+
+	// restart without the line keys
+	if assert.Len(sessions, 1) {
+		assert.Empty(sessions[0].CipherState)
+	}
+
+	B = open(Keys(keys), Transport(udp.Config{Network: "udp4", Addr: laddr}))
+	defer B.Close()
+	assert.NoError(B.ImportSessions(sessions))
+
+	y = B.GetExchange(ident.Hashname())
+	if assert.NotNil(y) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		state, err := y.WaitState(ctx, ExchangeIdle|ExchangeActive)
+		assert.NoError(err)
+		assert.True(state.IsOpen())
+		ping(y)
+	}
+}
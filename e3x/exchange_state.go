@@ -0,0 +1,67 @@
+package e3x
+
+import (
+	"context"
+)
+
+type stateChange struct {
+	from, to ExchangeState
+}
+
+// setState moves the exchange to state s and notifies the waiters and the
+// OnStateChanged hooks. The exchange must be locked.
+func (x *Exchange) setState(s ExchangeState) {
+	if x.state == s {
+		return
+	}
+
+	change := stateChange{x.state, s}
+	x.state = s
+	x.cndState.Broadcast()
+
+	x.stateChanges = append(x.stateChanges, change)
+	if !x.notifyingState {
+		x.notifyingState = true
+		go x.notifyStateChanges()
+	}
+}
+
+// notifyStateChanges calls the OnStateChanged hooks outside the lock of the
+// exchange, in the order of the changes.
+func (x *Exchange) notifyStateChanges() {
+	for {
+		x.mtx.Lock()
+		if len(x.stateChanges) == 0 {
+			x.notifyingState = false
+			x.mtx.Unlock()
+			return
+		}
+		change := x.stateChanges[0]
+		x.stateChanges = x.stateChanges[1:]
+		x.mtx.Unlock()
+
+		x.exchangeHooks.StateChanged(change.from, change.to)
+	}
+}
+
+// WaitState waits until the state of the exchange is one of the states in
+// mask (for example ExchangeIdle|ExchangeActive) and returns that state. It
+// returns ctx.Err() when ctx is done first. When the exchange is closed and
+// mask doesn't include the closed state a BrokenExchangeError is returned.
+func (x *Exchange) WaitState(ctx context.Context, mask ExchangeState) (ExchangeState, error) {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	err := waitContext(ctx, x.cndState, func() bool {
+		return x.state&mask == 0 && !x.state.IsClosed()
+	})
+	if err != nil {
+		return x.state, err
+	}
+
+	if x.state&mask == 0 {
+		return x.state, BrokenExchangeError(x.remoteIdent.Hashname())
+	}
+
+	return x.state, nil
+}
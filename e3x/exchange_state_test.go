@@ -0,0 +1,91 @@
+package e3x
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestExchangeWaitState(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		mtx     sync.Mutex
+		changes []ExchangeState
+	)
+
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		B.DefaultExchangeHooks().Register(ExchangeHook{
+			OnStateChanged: func(e *Endpoint, x *Exchange, from, to ExchangeState) error {
+				mtx.Lock()
+				defer mtx.Unlock()
+				if len(changes) > 0 {
+					assert.Equal(changes[len(changes)-1], from)
+				}
+				changes = append(changes, to)
+				return nil
+			},
+		})
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		x, err := B.Dial(ident)
+		if !assert.NoError(err) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		state, err := x.WaitState(ctx, ExchangeIdle|ExchangeActive)
+		assert.NoError(err)
+		assert.Equal(ExchangeIdle, state)
+
+		c, err := x.Open("state", false)
+		if !assert.NoError(err) {
+			return
+		}
+
+		state, err = x.WaitState(ctx, ExchangeActive)
+		assert.NoError(err)
+		assert.Equal(ExchangeActive, state)
+
+		c.Close()
+
+		state, err = x.WaitState(ctx, ExchangeIdle)
+		assert.NoError(err)
+		assert.Equal(ExchangeIdle, state)
+
+		short, cancelShort := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancelShort()
+		_, err = x.WaitState(short, ExchangeActive)
+		assert.Equal(context.DeadlineExceeded, err)
+
+		x.onBreak()
+
+		state, err = x.WaitState(ctx, ExchangeIdle)
+		assert.Equal(ExchangeBroken, state)
+		assert.Equal(BrokenExchangeError(ident.Hashname()), err)
+	})
+
+	// the hooks are called asynchronously
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		mtx.Lock()
+		n := len(changes)
+		mtx.Unlock()
+		if n >= 5 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal([]ExchangeState{
+		ExchangeDialing, ExchangeIdle, ExchangeActive, ExchangeIdle, ExchangeBroken,
+	}, changes)
+}
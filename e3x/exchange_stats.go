@@ -0,0 +1,81 @@
+package e3x
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ExchangeStats is a snapshot of the statistics of an exchange.
+type ExchangeStats struct {
+	PacketsSent        uint64            // channel packets sent (including retransmits and acks)
+	PacketsReceived    uint64            // channel packets received (including dropped packets)
+	BytesSent          uint64            // channel packet bytes sent on the wire
+	BytesReceived      uint64            // channel packet bytes received from the wire
+	HandshakesSent     uint64            // handshakes sent
+	HandshakesReceived uint64            // valid handshakes received
+	Rekeys             uint64            // completed line key rotations
+	Retransmits        uint64            // packets sent again by any channel (including closed channels)
+	Drops              map[string]uint64 // dropped packets and handshakes by reason (including channel drops)
+	Channels           int               // open channels
+	ReadBuffered       int               // packets of open channels which were not yet read
+	WriteBuffered      int               // packets of open channels which were not yet acknowledged
+	RTT                time.Duration     // latency of the active path
+}
+
+type exchangeCounters struct {
+	counters
+
+	handshakesSent     uint64
+	handshakesReceived uint64
+	rekeys             uint64
+}
+
+func (s *exchangeCounters) sentHandshake() {
+	atomic.AddUint64(&s.handshakesSent, 1)
+}
+
+func (s *exchangeCounters) receivedHandshake() {
+	atomic.AddUint64(&s.handshakesReceived, 1)
+}
+
+func (s *exchangeCounters) rekeyed() {
+	atomic.AddUint64(&s.rekeys, 1)
+}
+
+// Stats returns a snapshot of the exchange statistics.
+func (x *Exchange) Stats() ExchangeStats {
+	s := ExchangeStats{
+		PacketsSent:        atomic.LoadUint64(&x.stats.packetsSent),
+		PacketsReceived:    atomic.LoadUint64(&x.stats.packetsReceived),
+		BytesSent:          atomic.LoadUint64(&x.stats.bytesSent),
+		BytesReceived:      atomic.LoadUint64(&x.stats.bytesReceived),
+		HandshakesSent:     atomic.LoadUint64(&x.stats.handshakesSent),
+		HandshakesReceived: atomic.LoadUint64(&x.stats.handshakesReceived),
+		Rekeys:             atomic.LoadUint64(&x.stats.rekeys),
+		Retransmits:        atomic.LoadUint64(&x.stats.retransmits),
+		Drops:              x.stats.snapshotDrops(nil),
+		RTT:                x.addressBook.ActiveLatency(),
+	}
+
+	for _, c := range x.channels.All() {
+		cs := c.Stats()
+		s.Channels++
+		s.Retransmits += cs.Retransmits
+		s.ReadBuffered += cs.ReadBuffered
+		s.WriteBuffered += cs.WriteBuffered
+		for reason, n := range cs.Drops {
+			s.Drops[reason] += n
+		}
+	}
+
+	return s
+}
+
+// collectChannelStats moves the counters of a closed channel into the
+// counters of the exchange.
+func (x *Exchange) collectChannelStats(c *Channel) {
+	atomic.AddUint64(&x.stats.retransmits, atomic.LoadUint64(&c.stats.retransmits))
+	x.stats.mtx.Lock()
+	x.stats.drops = c.stats.snapshotDrops(x.stats.drops)
+	x.stats.mtx.Unlock()
+}
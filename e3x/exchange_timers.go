@@ -0,0 +1,64 @@
+package e3x
+
+import (
+	"time"
+)
+
+const (
+	cDefaultIdleTimeout      = 2 * time.Minute
+	cDefaultBreakTimeout     = 2 * time.Minute
+	cDefaultKeepalive        = 60 * time.Second
+	cDefaultHandshakeRetry   = 4 * time.Second
+	cDefaultHandshakeBackoff = 2.0
+)
+
+// IdleTimeout sets how long an exchange without open channels is kept
+// before it expires (the default is 2 minutes).
+func IdleTimeout(d time.Duration) ExchangeOption {
+	return func(x *Exchange) error {
+		if d > 0 {
+			x.idleTimeout = d
+		}
+		return nil
+	}
+}
+
+// BreakTimeout sets how long an exchange waits for a response to its
+// handshakes before it breaks (the default is 2 minutes). Dial fails after
+// the same timeout.
+func BreakTimeout(d time.Duration) ExchangeOption {
+	return func(x *Exchange) error {
+		if d > 0 {
+			x.breakTimeout = d
+		}
+		return nil
+	}
+}
+
+// Keepalive sets the interval at which an open exchange sends handshakes to
+// keep its paths alive (the default is 60 seconds). Peers behind NATs with
+// short mapping lifetimes need a shorter interval; servers can use a longer
+// one. The interval must be shorter than the break timeout of the peer.
+func Keepalive(interval time.Duration) ExchangeOption {
+	return func(x *Exchange) error {
+		if interval > 0 {
+			x.keepalive = interval
+		}
+		return nil
+	}
+}
+
+// HandshakeBackoff sets the delay before the first handshake is resent
+// (the default is 4 seconds) and the factor by which the delay grows with
+// every resend (the default is 2) until it reaches the keepalive interval.
+func HandshakeBackoff(initial time.Duration, factor float64) ExchangeOption {
+	return func(x *Exchange) error {
+		if initial > 0 {
+			x.handshakeRetry = initial
+		}
+		if factor >= 1 {
+			x.handshakeBackoff = factor
+		}
+		return nil
+	}
+}
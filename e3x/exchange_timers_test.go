@@ -0,0 +1,97 @@
+package e3x
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/transports/inproc"
+)
+
+func TestHandshakeBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	x := &Exchange{tDeliverHandshake: time.AfterFunc(time.Hour, func() {})}
+	defer x.tDeliverHandshake.Stop()
+
+	for _, option := range []ExchangeOption{
+		HandshakeBackoff(time.Second, 3),
+		Keepalive(5 * time.Second),
+		IdleTimeout(-1), // ignored
+	} {
+		assert.NoError(option(x))
+	}
+
+	for _, max := range []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second} {
+		x.rescheduleHandshake()
+		assert.True(x.nextHandshake <= max, "%s > %s", x.nextHandshake, max)
+	}
+
+	// capped at the keepalive interval (minus jitter)
+	for i := 0; i < 10; i++ {
+		x.rescheduleHandshake()
+		assert.True(x.nextHandshake <= 5*time.Second, "%s > 5s", x.nextHandshake)
+		assert.True(x.nextHandshake >= 3*time.Second, "%s < 3s", x.nextHandshake)
+	}
+
+	assert.Equal(time.Duration(0), x.idleTimeout)
+}
+
+func TestExchangeTimers(t *testing.T) {
+	assert := assert.New(t)
+
+	open := func(options ...ExchangeOption) *Endpoint {
+		e, err := Open(
+			Transport(inproc.Config{}),
+			DefaultExchangeOptions(options...),
+			DisableLog())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	A := open()
+	defer A.Close()
+	B := open(
+		IdleTimeout(200*time.Millisecond),
+		BreakTimeout(time.Minute),
+		Keepalive(20*time.Millisecond),
+		HandshakeBackoff(10*time.Millisecond, 1.5))
+	defer B.Close()
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	x, err := B.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	state, err := x.WaitState(ctx, ExchangeExpired)
+	assert.NoError(err)
+	assert.Equal(ExchangeExpired, state)
+
+	// the keepalives were sent in the meantime
+	assert.True(x.Stats().HandshakesSent >= 5, "only %d handshakes", x.Stats().HandshakesSent)
+
+	// an unanswered exchange breaks after the break timeout
+	C := open(BreakTimeout(100*time.Millisecond), Keepalive(20*time.Millisecond))
+	defer C.Close()
+
+	y, err := C.Dial(ident)
+	if !assert.NoError(err) {
+		return
+	}
+
+	A.Close()
+
+	state, err = y.WaitState(ctx, ExchangeBroken)
+	assert.NoError(err)
+	assert.Equal(ExchangeBroken, state)
+}
@@ -13,6 +13,8 @@ var (
 	statChannelSndPkt       *expvar.Int
 	statChannelSndAckInline *expvar.Int
 	statChannelSndAckAdHoc  *expvar.Int
+	statListenerPending     *expvar.Map
+	statListenerOverflow    *expvar.Map
 )
 
 func init() {
@@ -29,6 +31,8 @@ func resetStats() {
 	statChannelSndPkt = new(expvar.Int)
 	statChannelSndAckInline = new(expvar.Int)
 	statChannelSndAckAdHoc = new(expvar.Int)
+	statListenerPending = new(expvar.Map).Init()
+	statListenerOverflow = new(expvar.Map).Init()
 
 	statsMap.Set("channel.rcv.pkt", statChannelRcvPkt)
 	statsMap.Set("channel.rcv.pkt.drop", statChannelRcvPktDrop)
@@ -37,4 +41,6 @@ func resetStats() {
 	statsMap.Set("channel.snd.pkt", statChannelSndPkt)
 	statsMap.Set("channel.snd.ack.inline", statChannelSndAckInline)
 	statsMap.Set("channel.snd.ack.ad-hoc", statChannelSndAckAdHoc)
+	statsMap.Set("listener.pending", statListenerPending)
+	statsMap.Set("listener.overflow", statListenerOverflow)
 }
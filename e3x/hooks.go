@@ -3,6 +3,8 @@ package e3x
 import (
 	"errors"
 	"net"
+
+	"github.com/telehash/gogotelehash/internal/lob"
 )
 
 var ErrStopPropagation = errors.New("observer: stop propagation")
@@ -29,14 +31,20 @@ type EndpointHook struct {
 }
 
 type ExchangeHook struct {
-	OnOpened     func(*Endpoint, *Exchange) error
-	OnClosed     func(*Endpoint, *Exchange, error) error
-	OnDropPacket func(e *Endpoint, x *Exchange, msg []byte, pipe *Pipe, reason error) error
+	OnOpened       func(*Endpoint, *Exchange) error
+	OnClosed       func(*Endpoint, *Exchange, error) error
+	OnDropPacket   func(e *Endpoint, x *Exchange, msg []byte, pipe *Pipe, reason error) error
+	OnStateChanged func(e *Endpoint, x *Exchange, from, to ExchangeState) error
+	OnEvicted      func(e *Endpoint, x *Exchange) error
 }
 
 type ChannelHook struct {
 	OnOpened func(*Endpoint, *Exchange, *Channel) error
 	OnClosed func(*Endpoint, *Exchange, *Channel) error
+
+	// OnDropPacket is called while the packet is being processed; pkt is only
+	// valid during the call and the hook must not call methods of c.
+	OnDropPacket func(e *Endpoint, x *Exchange, c *Channel, pkt *lob.Packet, reason error) error
 }
 
 func (h *EndpointHooks) Register(hook EndpointHook) {
@@ -135,6 +143,24 @@ func (s *ExchangeHooks) DropPacket(msg []byte, pipe *Pipe, reason error) error {
 	})
 }
 
+func (s *ExchangeHooks) StateChanged(from, to ExchangeState) error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnStateChanged == nil {
+			return nil
+		}
+		return o.OnStateChanged(s.endpoint, s.exchange, from, to)
+	})
+}
+
+func (s *ExchangeHooks) Evicted() error {
+	return s.trigger(func(o ExchangeHook) error {
+		if o.OnEvicted == nil {
+			return nil
+		}
+		return o.OnEvicted(s.endpoint, s.exchange)
+	})
+}
+
 func (s *ChannelHooks) Opened() error {
 	return s.trigger(func(o ChannelHook) error {
 		if o.OnOpened == nil {
@@ -152,3 +178,12 @@ func (s *ChannelHooks) Closed() error {
 		return o.OnClosed(s.endpoint, s.exchange, s.channel)
 	})
 }
+
+func (s *ChannelHooks) DropPacket(pkt *lob.Packet, reason error) error {
+	return s.trigger(func(o ChannelHook) error {
+		if o.OnDropPacket == nil {
+			return nil
+		}
+		return o.OnDropPacket(s.endpoint, s.exchange, s.channel, pkt, reason)
+	})
+}
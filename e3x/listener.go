@@ -2,10 +2,14 @@ package e3x
 
 import (
 	"container/list"
-	"errors"
+	"context"
 	"io"
 	"net"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/telehash/gogotelehash/internal/hashname"
 )
 
 var (
@@ -15,19 +19,21 @@ var (
 const defaultBacklogSize = 512
 
 type listenerSet struct {
-	dropChannelFunc func(c *Channel, reason error)
-	addrFunc        func() net.Addr
+	dropChannelFunc  func(c *Channel, reason error)
+	admitChannelFunc func(hn hashname.H, typ string) error
+	addrFunc         func() net.Addr
 
 	mtx       sync.RWMutex
 	parent    *listenerSet
-	listeners map[string]*Listener
+	listeners map[listenerKey]*Listener
 }
 
-var (
-	ErrListenerClosed          = errors.New("listener closed")
-	ErrListenerBacklogTooLarge = errors.New("listener backlog too large")
-	ErrListenerInvalidType     = errors.New("listener inavlid channel type")
-)
+// listenerKey identifies a listener: the same type (or pattern) can have a
+// reliable and an unreliable listener.
+type listenerKey struct {
+	typ      string
+	reliable bool
+}
 
 func newListenerSet() *listenerSet {
 	return &listenerSet{}
@@ -49,7 +55,7 @@ func (set *listenerSet) Inherit() *listenerSet {
 	return &listenerSet{parent: set}
 }
 
-func (set *listenerSet) Get(typ string) *Listener {
+func (set *listenerSet) Get(typ string, reliable bool) *Listener {
 	var (
 		l *Listener
 	)
@@ -60,23 +66,81 @@ func (set *listenerSet) Get(typ string) *Listener {
 
 	set.mtx.RLock()
 	if set.listeners != nil {
-		l = set.listeners[typ]
+		l = set.listeners[listenerKey{typ, reliable}]
+		if l == nil {
+			l = set.match(typ, reliable)
+		}
 	}
 	set.mtx.RUnlock()
 
 	if l == nil {
-		l = set.parent.Get(typ)
+		l = set.parent.Get(typ, reliable)
 	}
 
 	return l
 }
 
-func (set *listenerSet) remove(typ string) {
+// match returns the pattern listener with the longest prefix of typ which
+// accepts channels of the same reliability. The set must be locked.
+func (set *listenerSet) match(typ string, reliable bool) *Listener {
+	var best *Listener
+
+	for key, l := range set.listeners {
+		pattern := key.typ
+		if key.reliable != reliable || !isTypePattern(pattern) || !matchType(pattern, typ) {
+			continue
+		}
+		if best == nil || len(pattern) > len(best.channelType) {
+			best = l
+		}
+	}
+
+	return best
+}
+
+func isTypePattern(pattern string) bool {
+	return strings.HasSuffix(pattern, "*")
+}
+
+// matchType reports whether typ matches pattern. A pattern ending in "*"
+// matches every type with the same prefix; "*" matches every type.
+func matchType(pattern, typ string) bool {
+	if isTypePattern(pattern) {
+		return strings.HasPrefix(typ, pattern[:len(pattern)-1])
+	}
+	return pattern == typ
+}
+
+// stats returns a snapshot of the listeners of the set (not of its parent).
+// The stats of the reliable and the unreliable listener of a type are added
+// up.
+func (set *listenerSet) stats() map[string]ListenerStats {
+	set.mtx.RLock()
+	listeners := make([]*Listener, 0, len(set.listeners))
+	for _, l := range set.listeners {
+		listeners = append(listeners, l)
+	}
+	set.mtx.RUnlock()
+
+	m := make(map[string]ListenerStats, len(listeners))
+	for _, l := range listeners {
+		s, ls := m[l.channelType], l.Stats()
+		s.Pending += ls.Pending
+		s.Waiting += ls.Waiting
+		s.Accepted += ls.Accepted
+		s.Overflows += ls.Overflows
+		s.Timeouts += ls.Timeouts
+		m[l.channelType] = s
+	}
+	return m
+}
+
+func (set *listenerSet) remove(typ string, reliable bool) {
 	set.mtx.Lock()
 	defer set.mtx.Unlock()
 
 	if set.listeners != nil {
-		delete(set.listeners, typ)
+		delete(set.listeners, listenerKey{typ, reliable})
 	}
 }
 
@@ -92,35 +156,149 @@ func (set *listenerSet) dropChannel(c *Channel, reason error) {
 	}
 }
 
-func (set *listenerSet) Listen(typ string, reliable bool) *Listener {
+// admitChannel returns an error when the peer hn may not open a channel of
+// type typ (see AccessPolicy).
+func (set *listenerSet) admitChannel(hn hashname.H, typ string) error {
+	if set == nil {
+		return nil
+	}
+
+	if set.admitChannelFunc != nil {
+		return set.admitChannelFunc(hn, typ)
+	}
+
+	return set.parent.admitChannel(hn, typ)
+}
+
+// Listen registers a listener for channels of type typ. When typ ends in "*"
+// the listener accepts every channel type with that prefix which has no
+// listener of its own (the longest prefix wins); "*" accepts every type. Only
+// listeners with the same reliability as the channel are considered, so a type
+// can have both a reliable and an unreliable listener.
+func (set *listenerSet) Listen(typ string, reliable bool, options ...ListenerOption) *Listener {
 	set.mtx.Lock()
 	defer set.mtx.Unlock()
 
 	if set.listeners == nil {
-		set.listeners = make(map[string]*Listener)
+		set.listeners = make(map[listenerKey]*Listener)
 	}
 
-	if _, f := set.listeners[typ]; f {
+	key := listenerKey{typ, reliable}
+	if _, f := set.listeners[key]; f {
 		panic("listener is already registered: " + typ)
 	}
 
 	l := newListener(set, typ, reliable, 0)
-	set.listeners[typ] = l
+	for _, option := range options {
+		option(l)
+	}
+	set.listeners[key] = l
 	return l
 }
 
+// ListenerOption configures a Listener.
+type ListenerOption func(*Listener)
+
+// AcceptOptions sets the options which are applied to the channels accepted
+// by the listener (for example PartialReliability or SendPriority).
+func AcceptOptions(options ...ChannelOption) ListenerOption {
+	return func(l *Listener) {
+		l.channelOptions = append(l.channelOptions, options...)
+	}
+}
+
+// MaxPending sets the size of the backlog: how many channels can wait to be
+// accepted (the default is 512). What happens to channels beyond the limit
+// depends on the OverflowPolicy.
+func MaxPending(n int) ListenerOption {
+	return func(l *Listener) {
+		if n > 0 {
+			l.maxBacklogSize = n
+		}
+	}
+}
+
+// AcceptTimeout rejects channels with RejectAcceptTimeout when they were not
+// accepted within d.
+func AcceptTimeout(d time.Duration) ListenerOption {
+	return func(l *Listener) {
+		if d > 0 {
+			l.acceptTimeout = d
+		}
+	}
+}
+
+// OverflowPolicy decides what happens to a channel which arrives while the
+// backlog of the listener is full.
+type OverflowPolicy int
+
+const (
+	// OverflowReject rejects the new channel with RejectBacklogFull.
+	OverflowReject OverflowPolicy = iota
+	// OverflowDropOldest rejects the oldest pending channel with
+	// RejectBacklogFull to make room for the new channel.
+	OverflowDropOldest
+	// OverflowBlock keeps the new channel waiting until there is room in the
+	// backlog. At most as many channels as fit in the backlog can wait, the
+	// channels beyond that are rejected with RejectBacklogFull. Use
+	// AcceptTimeout to bound the wait.
+	OverflowBlock
+)
+
+// Overflow sets the overflow policy of the listener (the default is
+// OverflowReject).
+func Overflow(policy OverflowPolicy) ListenerOption {
+	return func(l *Listener) {
+		l.overflow = policy
+	}
+}
+
+// ListenerStats is a snapshot of the accept queue of a listener.
+type ListenerStats struct {
+	Pending   int    // channels in the backlog
+	Waiting   int    // channels waiting for room in the backlog (OverflowBlock)
+	Accepted  uint64 // channels returned by Accept
+	Overflows uint64 // channels which arrived while the backlog was full
+	Timeouts  uint64 // channels rejected by the accept timeout
+}
+
 type Listener struct {
 	mtx sync.Mutex
 	cnd *sync.Cond
 
-	set         *listenerSet
-	channelType string
-	reliable    bool
+	set            *listenerSet
+	channelType    string
+	reliable       bool
+	channelOptions []ChannelOption
 
 	closed         bool
 	maxBacklogSize int
 	backlogSize    int
+	acceptTimeout  time.Duration
+	overflow       OverflowPolicy
 	queue          list.List
+	waiting        list.List
+
+	accepted  uint64
+	overflows uint64
+	timeouts  uint64
+
+	rejects []rejectedChannel
+}
+
+// rejectedChannel is a channel which is rejected once the listener is
+// unlocked.
+type rejectedChannel struct {
+	c      *Channel
+	reason error
+}
+
+// pendingChannel is an entry of the backlog or of the waiting list.
+type pendingChannel struct {
+	c     *Channel
+	elem  *list.Element
+	in    *list.List
+	timer *time.Timer
 }
 
 func newListener(set *listenerSet, channelType string, reliable bool, maxBacklogSize int) *Listener {
@@ -142,44 +320,179 @@ func newListener(set *listenerSet, channelType string, reliable bool, maxBacklog
 
 func (l *Listener) handle(c *Channel) {
 	l.mtx.Lock()
-	defer l.mtx.Unlock()
+	defer l.unlock()
 
 	if l.closed {
 		// forget about channel
-		l.set.dropChannel(c, ErrListenerClosed)
+		l.reject(c, ErrListenerClosed)
 		return
 	}
 
-	if l.maxBacklogSize > 0 && l.backlogSize >= l.maxBacklogSize {
+	if c.reliable != l.reliable || !matchType(l.channelType, c.typ) {
 		// forget about channel
-		l.set.dropChannel(c, ErrListenerBacklogTooLarge)
+		l.reject(c, ErrListenerInvalidType)
 		return
 	}
 
-	if c.reliable != l.reliable || c.typ != l.channelType {
-		// forget about channel
-		l.set.dropChannel(c, ErrListenerInvalidType)
-		return
+	p := &pendingChannel{c: c}
+
+	if l.maxBacklogSize > 0 && l.backlogSize >= l.maxBacklogSize {
+		l.overflows++
+		statListenerOverflow.Add(l.channelType, 1)
+
+		switch l.overflow {
+		case OverflowDropOldest:
+			l.drop(l.queue.Front().Value.(*pendingChannel), ErrListenerBacklogTooLarge)
+		case OverflowBlock:
+			if l.waiting.Len() < l.maxBacklogSize {
+				l.push(&l.waiting, p)
+			} else {
+				// forget about channel
+				l.drop(p, ErrListenerBacklogTooLarge)
+			}
+			return
+		default:
+			// forget about channel
+			l.drop(p, ErrListenerBacklogTooLarge)
+			return
+		}
 	}
 
-	l.queue.PushBack(c)
-	l.backlogSize++
+	l.push(&l.queue, p)
 	l.cnd.Signal()
 }
 
+// push adds p to the backlog or to the waiting list. The listener must be
+// locked.
+func (l *Listener) push(in *list.List, p *pendingChannel) {
+	if p.timer == nil && l.acceptTimeout > 0 {
+		p.timer = time.AfterFunc(l.acceptTimeout, func() { l.expire(p) })
+	}
+
+	p.in = in
+	p.elem = in.PushBack(p)
+	if in == &l.queue {
+		l.backlogSize++
+		statListenerPending.Add(l.channelType, 1)
+	}
+}
+
+// remove removes p from the backlog or the waiting list and moves the first
+// waiting channel into the backlog when there is room. The listener must be
+// locked.
+func (l *Listener) remove(p *pendingChannel) {
+	if p.in == nil {
+		return
+	}
+
+	p.in.Remove(p.elem)
+	if p.in == &l.queue {
+		l.backlogSize--
+		statListenerPending.Add(l.channelType, -1)
+	}
+	p.in = nil
+	p.elem = nil
+
+	if l.closed || l.backlogSize >= l.maxBacklogSize {
+		return
+	}
+
+	if elem := l.waiting.Front(); elem != nil {
+		next := elem.Value.(*pendingChannel)
+		l.waiting.Remove(elem)
+		l.push(&l.queue, next)
+		l.cnd.Signal()
+	}
+}
+
+// drop removes and rejects p. The listener must be locked.
+func (l *Listener) drop(p *pendingChannel, reason error) {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	l.remove(p)
+	l.reject(p.c, reason)
+}
+
+// reject queues c to be rejected once the listener is unlocked. The listener
+// must be locked.
+func (l *Listener) reject(c *Channel, reason error) {
+	l.rejects = append(l.rejects, rejectedChannel{c, reason})
+}
+
+// unlock unlocks the listener and then rejects the queued channels. Rejecting
+// a channel writes a packet, which must not happen while the listener is
+// locked.
+func (l *Listener) unlock() {
+	rejects := l.rejects
+	l.rejects = nil
+	l.mtx.Unlock()
+
+	for _, r := range rejects {
+		l.set.dropChannel(r.c, r.reason)
+	}
+}
+
+func (l *Listener) expire(p *pendingChannel) {
+	l.mtx.Lock()
+	defer l.unlock()
+
+	if p.in == nil {
+		// accepted or dropped
+		return
+	}
+
+	l.timeouts++
+	l.drop(p, ErrListenerAcceptTimeout)
+}
+
+// Stats returns a snapshot of the accept queue.
+func (l *Listener) Stats() ListenerStats {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return ListenerStats{
+		Pending:   l.backlogSize,
+		Waiting:   l.waiting.Len(),
+		Accepted:  l.accepted,
+		Overflows: l.overflows,
+		Timeouts:  l.timeouts,
+	}
+}
+
+// Addr returns the address of the listener (an *Addr).
 func (l *Listener) Addr() net.Addr {
 	if l == nil {
 		return nil
 	}
 
-	return l.set.Addr()
+	addr := &Addr{Type: l.channelType}
+	if a, ok := l.set.Addr().(*Addr); ok {
+		addr.Hashname = a.Hashname
+	}
+	return addr
 }
 
+// Accept implements the net.Listener Accept method. Once the listener is
+// closed Accept returns an error which wraps net.ErrClosed.
 func (l *Listener) Accept() (net.Conn, error) {
-	return l.AcceptChannel()
+	c, err := l.AcceptChannel()
+	if err == io.EOF {
+		return nil, &net.OpError{Op: "accept", Net: Network, Addr: l.Addr(), Err: net.ErrClosed}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 func (l *Listener) AcceptChannel() (*Channel, error) {
+	return l.AcceptChannelContext(context.Background())
+}
+
+// AcceptChannelContext is like AcceptChannel but returns ctx.Err() when ctx
+// is done before a channel was accepted.
+func (l *Listener) AcceptChannelContext(ctx context.Context) (*Channel, error) {
 	if l == nil {
 		return nil, io.EOF
 	}
@@ -188,8 +501,8 @@ func (l *Listener) AcceptChannel() (*Channel, error) {
 	defer l.mtx.Unlock()
 
 WAIT:
-	for !l.closed && l.backlogSize == 0 {
-		l.cnd.Wait()
+	if err := waitContext(ctx, l.cnd, l.blockAccept); err != nil {
+		return nil, err
 	}
 
 	if l.closed {
@@ -202,21 +515,22 @@ WAIT:
 	}
 
 	// remove from queue
-	l.queue.Remove(elem)
-	l.backlogSize--
-
-	// ignore nil values
-	if elem.Value == nil {
-		goto WAIT
+	p := elem.Value.(*pendingChannel)
+	if p.timer != nil {
+		p.timer.Stop()
 	}
-
-	c := elem.Value.(*Channel)
+	l.remove(p)
+	l.accepted++
 
 	if l.backlogSize > 0 {
 		l.cnd.Signal()
 	}
 
-	return c, nil
+	return p.c, nil
+}
+
+func (l *Listener) blockAccept() bool {
+	return !l.closed && l.backlogSize == 0
 }
 
 func (l *Listener) Close() error {
@@ -225,22 +539,24 @@ func (l *Listener) Close() error {
 	}
 
 	l.mtx.Lock()
-	defer l.mtx.Unlock()
+	defer l.unlock()
 
 	if l.closed {
 		return nil
 	}
 
 	if l.set != nil {
-		l.set.remove(l.channelType)
+		l.set.remove(l.channelType, l.reliable)
 	}
 
-	for e := l.queue.Front(); e != nil; e = e.Next() {
-		c := e.Value.(*Channel)
-		l.set.dropChannel(c, ErrListenerClosed)
+	l.closed = true
+
+	for _, in := range []*list.List{&l.queue, &l.waiting} {
+		for in.Len() > 0 {
+			l.drop(in.Front().Value.(*pendingChannel), ErrListenerClosed)
+		}
 	}
 
-	l.closed = true
 	l.cnd.Broadcast()
 	return nil
 }
@@ -0,0 +1,175 @@
+package e3x
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+// openPending opens n channels of type typ from B to A and writes the first
+// packet of each channel.
+func openPending(t *testing.T, A, B *Endpoint, typ string, n int) []*Channel {
+	assert := assert.New(t)
+
+	ident, err := A.LocalIdentity()
+	assert.NoError(err)
+
+	var channels []*Channel
+	for i := 0; i < n; i++ {
+		c, err := B.Open(ident, typ, true)
+		if !assert.NoError(err) {
+			return channels
+		}
+
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		assert.NoError(c.WritePacket(lob.New(nil)))
+		channels = append(channels, c)
+	}
+	return channels
+}
+
+// waitStats waits until f returns true for the stats of l.
+func waitStats(l *Listener, f func(s ListenerStats) bool) ListenerStats {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := l.Stats()
+		if f(s) || time.Now().After(deadline) {
+			return s
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListenerOverflowDropOldest(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		assert := assert.New(t)
+
+		l := A.Listen("pending", true, MaxPending(1), Overflow(OverflowDropOldest))
+		defer l.Close()
+
+		channels := openPending(t, A, B, "pending", 2)
+		if len(channels) != 2 {
+			return
+		}
+		defer channels[1].Close()
+
+		// the oldest channel made room for the new one
+		_, err := channels[0].ReadPacket()
+		if assert.IsType(&RejectedChannelError{}, err) {
+			assert.Equal(RejectBacklogFull, err.(*RejectedChannelError).Code)
+		}
+
+		c, err := l.AcceptChannel()
+		if assert.NoError(err) {
+			assert.Equal(channels[1].id, c.id)
+			c.ReadPacket()
+			go c.Close()
+		}
+
+		assert.Equal(ListenerStats{Accepted: 1, Overflows: 1}, l.Stats())
+	})
+}
+
+func TestListenerOverflowBlock(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		assert := assert.New(t)
+
+		l := A.Listen("pending", true, MaxPending(1), Overflow(OverflowBlock))
+		defer l.Close()
+
+		channels := openPending(t, A, B, "pending", 3)
+		for _, c := range channels {
+			defer c.Close()
+		}
+		if len(channels) != 3 {
+			return
+		}
+
+		// the waiting list is as large as the backlog
+		_, err := channels[2].ReadPacket()
+		if assert.IsType(&RejectedChannelError{}, err) {
+			assert.Equal(RejectBacklogFull, err.(*RejectedChannelError).Code)
+		}
+
+		s := waitStats(l, func(s ListenerStats) bool { return s.Waiting == 1 })
+		assert.Equal(ListenerStats{Pending: 1, Waiting: 1, Overflows: 2}, s)
+
+		// the waiting channel moves into the backlog
+		for _, expected := range channels[:2] {
+			c, err := l.AcceptChannel()
+			if assert.NoError(err) {
+				assert.Equal(expected.id, c.id)
+				c.ReadPacket()
+				go c.Close()
+			}
+		}
+
+		assert.Equal(ListenerStats{Accepted: 2, Overflows: 2}, l.Stats())
+		assert.Equal(uint64(2), A.Stats().Listeners["pending"].Overflows)
+	})
+}
+
+func TestListenerAcceptTimeout(t *testing.T) {
+	withTwoEndpoints(t, func(A, B *Endpoint) {
+		assert := assert.New(t)
+
+		l := A.Listen("pending", true, AcceptTimeout(50*time.Millisecond))
+		defer l.Close()
+
+		channels := openPending(t, A, B, "pending", 1)
+		if len(channels) != 1 {
+			return
+		}
+		defer channels[0].Close()
+
+		_, err := channels[0].ReadPacket()
+		if assert.IsType(&RejectedChannelError{}, err) {
+			assert.Equal(RejectAcceptTimeout, err.(*RejectedChannelError).Code)
+		}
+
+		assert.Equal(ListenerStats{Timeouts: 1}, l.Stats())
+		assert.Equal(ListenerStats{Timeouts: 1}, A.Stats().Listeners["pending"])
+	})
+}
+
+func TestListenerRejectUnlocked(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		l       *Listener
+		set     = newListenerSet()
+		dropped = make(chan ListenerStats, 3)
+	)
+
+	// rejecting a channel writes a packet; the listener must not be locked
+	set.dropChannelFunc = func(c *Channel, reason error) {
+		dropped <- l.Stats()
+	}
+
+	l = set.Listen("pending", true, MaxPending(1))
+	go func() {
+		l.handle(&Channel{typ: "pending", reliable: true})
+		l.handle(&Channel{typ: "pending", reliable: true})
+		l.handle(&Channel{typ: "other", reliable: true})
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case s := <-dropped:
+			assert.Equal(1, s.Pending)
+		case <-time.After(time.Second):
+			t.Fatal("listener is locked while rejecting channels")
+		}
+	}
+
+	go l.Close()
+	select {
+	case s := <-dropped:
+		assert.Equal(0, s.Pending)
+	case <-time.After(time.Second):
+		t.Fatal("listener is locked while rejecting channels")
+	}
+}
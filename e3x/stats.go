@@ -0,0 +1,55 @@
+package e3x
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// counters are the statistics collected by channels and exchanges. All
+// methods are safe for concurrent use.
+type counters struct {
+	packetsSent     uint64
+	packetsReceived uint64
+	bytesSent       uint64
+	bytesReceived   uint64
+	retransmits     uint64
+
+	mtx   sync.Mutex
+	drops map[string]uint64
+}
+
+func (s *counters) sent(n int) {
+	atomic.AddUint64(&s.packetsSent, 1)
+	atomic.AddUint64(&s.bytesSent, uint64(n))
+}
+
+func (s *counters) received(n int) {
+	atomic.AddUint64(&s.packetsReceived, 1)
+	atomic.AddUint64(&s.bytesReceived, uint64(n))
+}
+
+func (s *counters) retransmitted() {
+	atomic.AddUint64(&s.retransmits, 1)
+}
+
+func (s *counters) drop(reason string) {
+	s.mtx.Lock()
+	if s.drops == nil {
+		s.drops = make(map[string]uint64)
+	}
+	s.drops[reason]++
+	s.mtx.Unlock()
+}
+
+func (s *counters) snapshotDrops(m map[string]uint64) map[string]uint64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if m == nil {
+		m = make(map[string]uint64, len(s.drops))
+	}
+	for reason, n := range s.drops {
+		m[reason] += n
+	}
+	return m
+}
@@ -0,0 +1,104 @@
+package e3x
+
+import (
+	"testing"
+
+	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+
+	"github.com/telehash/gogotelehash/internal/lob"
+)
+
+func TestCounters(t *testing.T) {
+	assert := assert.New(t)
+
+	var s counters
+	s.sent(10)
+	s.sent(5)
+	s.received(7)
+	s.retransmitted()
+	s.drop("a")
+	s.drop("a")
+	s.drop("b")
+
+	assert.Equal(uint64(2), s.packetsSent)
+	assert.Equal(uint64(15), s.bytesSent)
+	assert.Equal(uint64(1), s.packetsReceived)
+	assert.Equal(uint64(7), s.bytesReceived)
+	assert.Equal(uint64(1), s.retransmits)
+
+	drops := s.snapshotDrops(map[string]uint64{"a": 1})
+	assert.Equal(map[string]uint64{"a": 3, "b": 1}, drops)
+}
+
+func TestStats(t *testing.T) {
+	withTwoLossyEndpoints(t, 0, func(A, B *Endpoint) {
+		var (
+			assert = assert.New(t)
+			done   = make(chan struct{})
+		)
+
+		go func() {
+			defer close(done)
+
+			c, err := A.Listen("ping", true).AcceptChannel()
+			if !assert.NoError(err) {
+				return
+			}
+			defer c.Close()
+
+			for i := 0; i < 10; i++ {
+				pkt, err := c.ReadPacket()
+				if !assert.NoError(err) {
+					return
+				}
+				assert.NoError(c.WritePacket(pkt))
+			}
+		}()
+
+		ident, err := A.LocalIdentity()
+		assert.NoError(err)
+
+		c, err := B.Open(ident, "ping", true)
+		if !assert.NoError(err) {
+			return
+		}
+
+		for i := 0; i < 10; i++ {
+			assert.NoError(c.WritePacket(lob.New([]byte("ping"))))
+			_, err := c.ReadPacket()
+			assert.NoError(err)
+		}
+
+		cs := c.Stats()
+		assert.Equal(uint64(10), cs.PacketsSent)
+		assert.Equal(uint64(40), cs.BytesSent)
+		assert.True(cs.PacketsReceived >= 10)
+		assert.Equal(uint64(40), cs.BytesReceived)
+
+		xs := c.Exchange().Stats()
+		assert.True(xs.PacketsSent >= 10)
+		assert.True(xs.BytesSent > 40)
+		assert.True(xs.PacketsReceived >= 10)
+		assert.True(xs.HandshakesSent >= 1)
+		assert.True(xs.HandshakesReceived >= 1)
+		assert.Equal(1, xs.Channels)
+
+		es := B.Stats()
+		assert.Equal(1, es.Exchanges)
+		assert.Equal(1, es.Channels)
+		assert.Equal(xs.HandshakesSent, es.HandshakesSent)
+
+		assert.NoError(c.Close())
+		<-done
+
+		// counters are kept after the exchange is closed
+		c.Exchange().onExpire()
+		es = B.Stats()
+		assert.Equal(0, es.Exchanges)
+		assert.Equal(0, es.Channels)
+		assert.True(es.PacketsSent >= xs.PacketsSent)
+		assert.True(es.BytesSent >= xs.BytesSent)
+		assert.True(es.PacketsReceived >= xs.PacketsReceived)
+		assert.Equal(xs.HandshakesSent, es.HandshakesSent)
+	})
+}
@@ -1,12 +1,16 @@
 package e3x
 
 import (
+	"math/rand"
+	"net"
 	"testing"
 
 	"github.com/telehash/gogotelehash/Godeps/_workspace/src/github.com/stretchr/testify/mock"
 
 	"github.com/telehash/gogotelehash/internal/lob"
 	"github.com/telehash/gogotelehash/internal/util/tracer"
+	"github.com/telehash/gogotelehash/transports"
+	"github.com/telehash/gogotelehash/transports/inproc"
 )
 
 type MockExchange struct {
@@ -32,3 +36,79 @@ func dumpExpVar(tb testing.TB) {
 	tb.Logf("stat: %s", statsMap)
 	resetStats()
 }
+
+// withEndpointHook registers h before the endpoint starts accepting packets.
+func withEndpointHook(h EndpointHook) EndpointOption {
+	return func(e *Endpoint) error {
+		e.Hooks().Register(h)
+		return nil
+	}
+}
+
+// lossyConfig wraps a transport and randomly drops a fraction of the
+// channel packets written to it. Handshakes are never dropped.
+type lossyConfig struct {
+	Config transports.Config
+	Loss   float64
+}
+
+type lossyTransport struct {
+	transports.Transport
+	loss float64
+}
+
+type lossyConn struct {
+	net.Conn
+	loss float64
+}
+
+func (c lossyConfig) Open() (transports.Transport, error) {
+	t, err := c.Config.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &lossyTransport{t, c.Loss}, nil
+}
+
+func (t *lossyTransport) Dial(addr net.Addr) (net.Conn, error) {
+	conn, err := t.Transport.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &lossyConn{conn, t.loss}, nil
+}
+
+func (t *lossyTransport) Accept() (net.Conn, error) {
+	conn, err := t.Transport.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &lossyConn{conn, t.loss}, nil
+}
+
+func (c *lossyConn) Write(p []byte) (int, error) {
+	if len(p) >= 2 && p[0] == 0 && p[1] == 0 && rand.Float64() < c.loss {
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+func withTwoLossyEndpoints(t testing.TB, loss float64, f func(a, b *Endpoint)) {
+	open := func() *Endpoint {
+		e, err := Open(
+			Transport(lossyConfig{inproc.Config{}, loss}),
+			DisableLog())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	a := open()
+	defer a.Close()
+
+	b := open()
+	defer b.Close()
+
+	f(a, b)
+}
@@ -1,7 +1,6 @@
 package bridge
 
 import (
-	"io"
 	"sync"
 	"time"
 
@@ -24,15 +23,13 @@ type Bridge interface {
 }
 
 type module struct {
-	mtx             sync.RWMutex
-	e               *e3x.Endpoint
-	config          Config
-	peerListener    *e3x.Listener
-	connectListener *e3x.Listener
-	pending         map[hashname.H]*pendingIntroduction
-	packetRoutes    map[cipherset.Token]*e3x.Exchange
-	connections     map[*e3x.Exchange]map[cipherset.Token]*connection
-	log             *logs.Logger
+	mtx          sync.RWMutex
+	e            *e3x.Endpoint
+	config       Config
+	pending      map[hashname.H]*pendingIntroduction
+	packetRoutes map[cipherset.Token]*e3x.Exchange
+	connections  map[*e3x.Exchange]map[cipherset.Token]*connection
+	log          *logs.Logger
 }
 
 type pendingIntroduction struct {
@@ -85,18 +82,17 @@ func (mod *module) Init() error {
 }
 
 func (mod *module) Start() error {
-	mod.peerListener = mod.e.Listen("peer", false)
-	mod.connectListener = mod.e.Listen("connect", false)
-
-	go mod.acceptPeerChannels()
-	go mod.acceptConnectChannels()
+	mux := e3x.ChannelMuxFromEndpoint(mod.e)
+	mux.HandleFunc("peer", false, mod.handle_peer)
+	mux.HandleFunc("connect", false, mod.handle_connect)
 
 	return nil
 }
 
 func (mod *module) Stop() error {
-	mod.peerListener.Close()
-	mod.connectListener.Close()
+	mux := e3x.ChannelMuxFromEndpoint(mod.e)
+	mux.Remove("peer", false)
+	mux.Remove("connect", false)
 
 	return nil
 }
@@ -172,32 +168,6 @@ func (i *pendingIntroduction) resolve(x *e3x.Exchange, err error) {
 	i.mod.mtx.Unlock()
 }
 
-func (mod *module) acceptPeerChannels() {
-	for {
-		c, err := mod.peerListener.AcceptChannel()
-		if err == io.EOF {
-			return
-		}
-		if err != nil {
-			continue
-		}
-		go mod.handle_peer(c)
-	}
-}
-
-func (mod *module) acceptConnectChannels() {
-	for {
-		c, err := mod.connectListener.AcceptChannel()
-		if err == io.EOF {
-			return
-		}
-		if err != nil {
-			continue
-		}
-		go mod.handle_connect(c)
-	}
-}
-
 func (mod *module) RouteToken(token cipherset.Token, source *e3x.Exchange) {
 	mod.mtx.Lock()
 	mod.packetRoutes[token] = source
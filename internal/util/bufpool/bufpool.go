@@ -51,6 +51,10 @@ func (b *Buffer) Len() int {
 }
 
 func (b *Buffer) Get(buf []byte) []byte {
+	if b == nil {
+		return buf
+	}
+
 	b.secure()
 	return append(buf, b.bytes...)
 }
@@ -39,6 +39,7 @@ type inprocAddr struct {
 type transport struct {
 	laddr *inprocAddr
 	c     chan packet
+	done  chan struct{}
 }
 
 type packet struct {
@@ -62,7 +63,7 @@ var (
 func (c Config) Open() (transports.Transport, error) {
 	mtx.Lock()
 	id := netxID
-	t := &transport{&inprocAddr{id}, make(chan packet, 10)}
+	t := &transport{&inprocAddr{id}, make(chan packet, 10), make(chan struct{})}
 	netxID++
 	pipes[id] = t
 	mtx.Unlock()
@@ -79,8 +80,10 @@ func (t *transport) NormalizeAddr(addr net.Addr) (dgram.Addr, error) {
 }
 
 func (t *transport) Read(p []byte) (int, dgram.Addr, error) {
-	pkt, open := <-t.c
-	if !open {
+	var pkt packet
+	select {
+	case pkt = <-t.c:
+	case <-t.done:
 		return 0, nil, io.EOF
 	}
 
@@ -106,10 +109,11 @@ func (t *transport) Write(p []byte, dst dgram.Addr) (int, error) {
 
 	buf := bufpool.New().Set(p)
 
-	func() {
-		defer func() { recover() }()
-		dstT.c <- packet{t.laddr, buf}
-	}()
+	select {
+	case dstT.c <- packet{t.laddr, buf}:
+	case <-dstT.done:
+		buf.Free() // drop
+	}
 
 	return len(p), nil
 }
@@ -123,7 +127,7 @@ func (t *transport) Close() error {
 	delete(pipes, t.laddr.id)
 	mtx.Unlock()
 
-	close(t.c)
+	close(t.done)
 	return nil
 }
 